@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mpolden/fs/crawler"
+	"github.com/mpolden/fs/sql"
+)
+
+type Daemon struct {
+	opts
+	Logger *log.Logger
+	Wait   bool     `long:"wait" description:"Wait for another process updating the database to finish instead of failing"`
+	Sites  []string `short:"s" long:"site" description:"Schedule a single site" value-name:"NAME"`
+}
+
+// scheduler crawls sites on their schedules. At most cap(sem) crawls run at the same time.
+type scheduler struct {
+	db     *sql.Client
+	logger *log.Logger
+	sem    chan bool
+	now    func() time.Time
+	after  func(time.Duration) <-chan time.Time
+	jitter func(max time.Duration) time.Duration
+	crawl  func(context.Context, crawler.Site) crawler.Report
+	lock   func(context.Context) (*sql.Lock, error)
+	mu     sync.Mutex
+	// The database lock is shared by the running crawls, and released when the last of them finishes
+	lockMu sync.Mutex
+	held   *sql.Lock
+	users  int
+}
+
+func newScheduler(db *sql.Client, filename string, wait bool, logger *log.Logger, concurrency int) *scheduler {
+	s := &scheduler{
+		db:     db,
+		logger: logger,
+		sem:    make(chan bool, concurrency),
+		now:    time.Now,
+		after:  time.After,
+		jitter: randomJitter,
+	}
+	s.crawl = func(ctx context.Context, site crawler.Site) crawler.Report {
+		c := crawler.New(site, db, logger)
+		c.UpdateContext(ctx)
+		return c.Report()
+	}
+	s.lock = func(ctx context.Context) (*sql.Lock, error) {
+		return lockDatabaseContext(ctx, filename, wait, logger)
+	}
+	return s
+}
+
+func randomJitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// nextCrawl returns when to start the crawl following one started at last. Scheduled times that have already passed
+// at now are skipped, so a crawl that overruns its schedule delays the next one instead of causing a burst of crawls.
+func nextCrawl(next func(time.Time) time.Time, last, now time.Time) time.Time {
+	t := next(last)
+	if t.Before(now) {
+		return next(now)
+	}
+	return t
+}
+
+// firstCrawl returns when to start the first crawl of site. Sites that have never been crawled, or that missed a
+// scheduled crawl while the daemon was not running, are crawled immediately.
+func (s *scheduler) firstCrawl(site crawler.Site) time.Time {
+	now := s.now()
+	var last int64
+	for _, success := range []bool{true, false} {
+		c, err := s.db.LastCrawl(site.Name, success)
+		if err != nil {
+			s.logger.Printf("[%s] Failed to read last crawl: %s", site.Name, err)
+			return site.NextCrawl(now)
+		}
+		if c != nil && c.Started > last {
+			last = c.Started
+		}
+	}
+	if last == 0 {
+		return now
+	}
+	if t := site.NextCrawl(time.Unix(last, 0)); t.After(now) {
+		return t
+	}
+	return now
+}
+
+// schedule crawls site on its schedule until ctx is cancelled. Crawls of the same site never overlap because the
+// next crawl is only scheduled when the previous one has finished.
+func (s *scheduler) schedule(ctx context.Context, site crawler.Site) {
+	next := s.firstCrawl(site)
+	for {
+		wait := next.Sub(s.now()) + s.jitter(site.MaxJitter())
+		if wait < 0 {
+			wait = 0
+		}
+		s.logger.Printf("[%s] Next crawl in %s", site.Name, wait.Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.after(wait):
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case s.sem <- true:
+		}
+		if ctx.Err() != nil {
+			<-s.sem
+			return
+		}
+		started := s.now()
+		if err := s.acquireLock(ctx); err != nil {
+			<-s.sem
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Printf("[%s] Skipping crawl: %s", site.Name, err)
+			next = nextCrawl(site.NextCrawl, started, s.now())
+			continue
+		}
+		report := s.crawl(ctx, site)
+		<-s.sem
+		logSummary(s.logger, []crawler.Report{report})
+		s.checkWatches()
+		s.releaseLock()
+		next = nextCrawl(site.NextCrawl, started, s.now())
+	}
+}
+
+// acquireLock acquires the database lock for a crawl, unless a running crawl already holds it.
+func (s *scheduler) acquireLock(ctx context.Context) error {
+	s.lockMu.Lock()
+	defer s.lockMu.Unlock()
+	if s.users == 0 {
+		lock, err := s.lock(ctx)
+		if err != nil {
+			return err
+		}
+		s.held = lock
+	}
+	s.users++
+	return nil
+}
+
+// releaseLock releases the database lock when no other crawl is running.
+func (s *scheduler) releaseLock() {
+	s.lockMu.Lock()
+	defer s.lockMu.Unlock()
+	s.users--
+	if s.users == 0 {
+		if err := s.held.Release(); err != nil {
+			s.logger.Printf("Failed to release lock: %s", err)
+		}
+		s.held = nil
+	}
+}
+
+// checkWatches checks saved searches after a crawl. Crawls finishing at the same time check them one at a time.
+func (s *scheduler) checkWatches() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := checkWatches(s.db, s.logger); n > 0 {
+		s.logger.Printf("%d watches could not be checked", n)
+	}
+}
+
+// run schedules crawls of sites until ctx is cancelled, and then waits for running crawls to finish.
+func (s *scheduler) run(ctx context.Context, sites []crawler.Site) {
+	var wg sync.WaitGroup
+	for _, site := range sites {
+		wg.Add(1)
+		go func(site crawler.Site) {
+			defer wg.Done()
+			s.schedule(ctx, site)
+		}(site)
+	}
+	wg.Wait()
+}
+
+func (d *Daemon) scheduleSite(site crawler.Site) bool {
+	if site.Skip || site.Schedule == "" {
+		return false
+	}
+	for _, name := range d.Sites {
+		if name == site.Name {
+			return true
+		}
+	}
+	return len(d.Sites) == 0
+}
+
+func (d *Daemon) Execute(args []string) error {
+	if len(args) != 0 {
+		return errUnexpectedArgs
+	}
+	cfg := mustReadConfig(d.Config)
+	var sites []crawler.Site
+	for _, site := range cfg.Sites {
+		if d.scheduleSite(site) {
+			sites = append(sites, site)
+		}
+	}
+	if len(sites) == 0 {
+		return fmt.Errorf("no sites to schedule: set Schedule on at least one site")
+	}
+	// Migrations write to the database, and the lock is otherwise only held while crawling
+	lock, err := lockDatabase(cfg.Database, d.Wait, d.Logger)
+	if err != nil {
+		return err
+	}
+	db, err := sql.New(cfg.Database)
+	lock.Release()
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	s := newScheduler(db, cfg.Database, d.Wait, d.Logger, cfg.Concurrency)
+	done := make(chan bool)
+	go func() {
+		s.run(ctx, sites)
+		close(done)
+	}()
+	d.Logger.Printf("Scheduled %d sites", len(sites))
+	<-ctx.Done()
+	// Restore default signal handling so that a second signal terminates immediately
+	stop()
+	d.Logger.Printf("Shutting down, stopping running crawls")
+	<-done
+	return nil
+}
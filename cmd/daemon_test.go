@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mpolden/fs/crawler"
+	"github.com/mpolden/fs/sql"
+)
+
+func TestDaemonExecute(t *testing.T) {
+	err := (&Daemon{}).Execute([]string{"foo"})
+	if err != errUnexpectedArgs {
+		t.Errorf("Expected error: %s", errUnexpectedArgs)
+	}
+}
+
+func testScheduler(t *testing.T, concurrency int) *scheduler {
+	db, err := sql.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newScheduler(db, ":memory:", false, log.New(ioutil.Discard, "", 0), concurrency)
+	s.now = func() time.Time { return time.Unix(10000, 0) }
+	s.jitter = func(time.Duration) time.Duration { return 0 }
+	return s
+}
+
+func testSite(t *testing.T, schedule string) crawler.Site {
+	dir, err := ioutil.TempDir("", "fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "fsrc")
+	config := `{"Database": "foo.db", "Concurrency": 1, "Sites": [{"Name": "foo", "Schedule": "` + schedule + `", "ConnectTimeout": "1s", "ReadTimeout": "1s"}]}`
+	if err := ioutil.WriteFile(name, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := crawler.ReadConfig(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Sites[0]
+}
+
+func TestNextCrawl(t *testing.T) {
+	hourly := func(t time.Time) time.Time { return t.Add(time.Hour) }
+	var tests = []struct {
+		last, now, want int64
+	}{
+		{0, 60, 3600},
+		{0, 3600, 3600},
+		// Overran the schedule
+		{0, 4000, 7600},
+	}
+	for _, tt := range tests {
+		if got := nextCrawl(hourly, time.Unix(tt.last, 0), time.Unix(tt.now, 0)); got.Unix() != tt.want {
+			t.Errorf("nextCrawl(%d, %d) = %d, want %d", tt.last, tt.now, got.Unix(), tt.want)
+		}
+	}
+}
+
+func TestFirstCrawl(t *testing.T) {
+	s := testScheduler(t, 1)
+	site := testSite(t, "1h")
+	now := s.now()
+	if got := s.firstCrawl(site); !got.Equal(now) {
+		t.Errorf("got %s, want %s for site never crawled", got, now)
+	}
+	if err := s.db.InsertCrawl(sql.Crawl{Site: "foo", Started: now.Unix() - 7200, Outcome: sql.OutcomeSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.firstCrawl(site); !got.Equal(now) {
+		t.Errorf("got %s, want %s for missed crawl", got, now)
+	}
+	if err := s.db.InsertCrawl(sql.Crawl{Site: "foo", Started: now.Unix() - 600, Outcome: sql.OutcomeFailure}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.firstCrawl(site), now.Add(50*time.Minute); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestSchedulerRun(t *testing.T) {
+	s := testScheduler(t, 2)
+	s.after = func(time.Duration) <-chan time.Time {
+		ch := make(chan time.Time)
+		close(ch)
+		return ch
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	running := 0
+	maxRunning := 0
+	crawls := 0
+	site := make(map[string]bool)
+	s.crawl = func(ctx context.Context, c crawler.Site) crawler.Report {
+		mu.Lock()
+		if site[c.Name] {
+			t.Errorf("overlapping crawls of %s", c.Name)
+		}
+		site[c.Name] = true
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		crawls++
+		if crawls == 20 {
+			cancel()
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		site[c.Name] = false
+		running--
+		return crawler.Report{Site: c.Name}
+	}
+	s.run(ctx, []crawler.Site{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}})
+	mu.Lock()
+	defer mu.Unlock()
+	if running != 0 {
+		t.Errorf("run returned with %d crawls running", running)
+	}
+	if maxRunning != 2 {
+		t.Errorf("got at most %d concurrent crawls, want 2", maxRunning)
+	}
+	if crawls < 20 {
+		t.Errorf("got %d crawls, want at least 20", crawls)
+	}
+}
+
+func TestSchedulerLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := filepath.Join(dir, "fs.db")
+	s := testScheduler(t, 2)
+	s.after = func(time.Duration) <-chan time.Time {
+		ch := make(chan time.Time)
+		close(ch)
+		return ch
+	}
+	var mu sync.Mutex
+	locks := 0
+	s.lock = func(ctx context.Context) (*sql.Lock, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		locks++
+		if locks == 1 {
+			return nil, errors.New("locked by another process")
+		}
+		return sql.AcquireLock(db)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	crawls := 0
+	s.crawl = func(ctx context.Context, c crawler.Site) crawler.Report {
+		if _, err := os.Stat(sql.LockFile(db)); err != nil {
+			t.Errorf("crawl without holding the lock: %s", err)
+		}
+		mu.Lock()
+		crawls++
+		if crawls == 10 {
+			cancel()
+			// Running crawls are stopped by the same context
+			if ctx.Err() == nil {
+				t.Error("crawl context not cancelled")
+			}
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		return crawler.Report{Site: c.Name}
+	}
+	s.run(ctx, []crawler.Site{{Name: "a"}, {Name: "b"}, {Name: "c"}})
+	if _, err := os.Stat(sql.LockFile(db)); !os.IsNotExist(err) {
+		t.Errorf("lock held after run returned: %v", err)
+	}
+	if locks < 2 {
+		t.Errorf("got %d attempts to lock, want at least 2", locks)
+	}
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mpolden/fs/sql"
+)
+
+type DB struct{}
+
+type DBMigrate struct {
+	opts
+	Dryrun bool `short:"n" long:"dry-run" description:"Only show migrations that would be applied"`
+}
+
+func (c *DBMigrate) Execute(args []string) error {
+	if len(args) != 0 {
+		return errUnexpectedArgs
+	}
+	cfg := mustReadConfig(c.Config)
+	if !c.Dryrun {
+		lock, err := lockDatabase(cfg.Database, false, nil)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
+	db, err := sql.Open(cfg.Database)
+	if err != nil {
+		return err
+	}
+	version, err := db.Version()
+	if err != nil {
+		return err
+	}
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Printf("Database is up to date at version %d\n", version)
+		return nil
+	}
+	if c.Dryrun {
+		for _, m := range pending {
+			fmt.Printf("Would migrate to version %d: %s\n", m.Version, m.Description)
+		}
+		return nil
+	}
+	migrated, err := db.Migrate()
+	for _, m := range migrated {
+		fmt.Printf("Migrated to version %d: %s\n", m.Version, m.Description)
+	}
+	return err
+}
+
+type DBReindex struct {
+	opts
+	Components bool `long:"components" description:"Index each path component separately, so that phrases do not match across /"`
+}
+
+func (c *DBReindex) Execute(args []string) error {
+	if len(args) != 0 {
+		return errUnexpectedArgs
+	}
+	cfg := mustReadConfig(c.Config)
+	lock, err := lockDatabase(cfg.Database, false, nil)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	db, err := sql.Open(cfg.Database)
+	if err != nil {
+		return err
+	}
+	return db.Reindex(sql.IndexOptions{Components: c.Components})
+}
@@ -0,0 +1,17 @@
+package cmd
+
+import "testing"
+
+func TestDBMigrateExecute(t *testing.T) {
+	err := (&DBMigrate{}).Execute([]string{"foo"})
+	if err != errUnexpectedArgs {
+		t.Errorf("Expected error: %s", errUnexpectedArgs)
+	}
+}
+
+func TestDBReindexExecute(t *testing.T) {
+	err := (&DBReindex{}).Execute([]string{"foo"})
+	if err != errUnexpectedArgs {
+		t.Errorf("Expected error: %s", errUnexpectedArgs)
+	}
+}
@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mpolden/fs/crawler"
+	"github.com/mpolden/fs/sql"
+)
+
+func formatRFC3339(t int64) string { return time.Unix(t, 0).UTC().Format(time.RFC3339) }
+
+// jsonWriter writes results as a JSON array, with one result per line.
+type jsonWriter struct {
+	w *bufio.Writer
+	n int
+}
+
+func newJSONWriter(w io.Writer) *jsonWriter { return &jsonWriter{w: bufio.NewWriter(w)} }
+
+func (w *jsonWriter) Write(d sql.Dir) error { return w.write(sql.NewResult(d)) }
+
+func (w *jsonWriter) write(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if w.n == 0 {
+		sep = "[\n"
+	}
+	w.n++
+	w.w.WriteString(sep)
+	_, err = w.w.Write(b)
+	return err
+}
+
+func (w *jsonWriter) Flush() error {
+	if w.n == 0 {
+		w.w.WriteString("[]\n")
+	} else {
+		w.w.WriteString("\n]\n")
+	}
+	return w.w.Flush()
+}
+
+// ndjsonWriter writes results as newline-delimited JSON.
+type ndjsonWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newNDJSONWriter(w io.Writer) *ndjsonWriter {
+	bw := bufio.NewWriter(w)
+	return &ndjsonWriter{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (w *ndjsonWriter) Write(d sql.Dir) error { return w.enc.Encode(sql.NewResult(d)) }
+
+func (w *ndjsonWriter) Flush() error { return w.w.Flush() }
+
+// csvWriter writes results as CSV, with a header naming the fields.
+type csvWriter struct{ w *csv.Writer }
+
+func newCSVWriter(w io.Writer) *csvWriter { return newCSVWriterHeader(w, sql.ResultFields) }
+
+func newCSVWriterHeader(w io.Writer, header []string) *csvWriter {
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	return &csvWriter{w: cw}
+}
+
+func (w *csvWriter) Write(d sql.Dir) error { return w.w.Write(sql.NewResult(d).Record()) }
+
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// writeStructuredCounts writes counts as written by writeCounts in the json, ndjson and csv formats.
+func writeStructuredCounts(w io.Writer, format, facet string, facets []sql.Facet) error {
+	switch format {
+	case "csv":
+		header := []string{"count"}
+		if facet != "" {
+			header = []string{facet, "count"}
+		}
+		cw := newCSVWriterHeader(w, header)
+		for _, fc := range facets {
+			record := []string{strconv.FormatInt(fc.Count, 10)}
+			if facet != "" {
+				record = []string{fc.Value, record[0]}
+			}
+			cw.w.Write(record)
+		}
+		return cw.Flush()
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for _, fc := range facets {
+			if err := enc.Encode(countValue(facet, fc)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	jw := newJSONWriter(w)
+	for _, fc := range facets {
+		if err := jw.write(countValue(facet, fc)); err != nil {
+			return err
+		}
+	}
+	return jw.Flush()
+}
+
+// countValue returns the JSON value of a count. Facets are written as objects with the facet as a key.
+func countValue(facet string, fc sql.Facet) interface{} {
+	if facet == "" {
+		return map[string]int64{"count": fc.Count}
+	}
+	return map[string]interface{}{facet: fc.Value, "count": fc.Count}
+}
+
+// templateResult is a search result as seen by templates. It holds the configuration of the site the result was found
+// on, except for credentials.
+type templateResult struct {
+	sql.Dir
+	Name     string
+	Parent   string
+	Address  string // Address of the site, as configured
+	Host     string // Same as Address
+	Hostname string // Address of the site without port
+	Root     string
+	URL      string // URL of the result, see urlWriter
+}
+
+// templateFuncs are the functions available to templates, in addition to those built into text/template.
+var templateFuncs = template.FuncMap{
+	"date": func(layout string, t int64) string { return time.Unix(t, 0).UTC().Format(layout) },
+	"size": formatSize,
+	"escapepath": func(p string) string {
+		parts := strings.Split(p, "/")
+		for i, part := range parts {
+			parts[i] = url.PathEscape(part)
+		}
+		return strings.Join(parts, "/")
+	},
+	"escapequery": url.QueryEscape,
+	"base":        path.Base,
+	"dir":         path.Dir,
+	"ext":         path.Ext,
+	"join":        path.Join,
+	"trimprefix":  func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+}
+
+// siteMap returns sites by name.
+func siteMap(sites []crawler.Site) map[string]crawler.Site {
+	m := make(map[string]crawler.Site, len(sites))
+	for _, s := range sites {
+		m[s.Name] = s
+	}
+	return m
+}
+
+// templateWriter writes each result by executing a template.
+type templateWriter struct {
+	w     *bufio.Writer
+	tmpl  *template.Template
+	sites map[string]crawler.Site
+}
+
+func newTemplateWriter(w io.Writer, text string, sites []crawler.Site) (*templateWriter, error) {
+	// Results are written on separate lines, unless the template already ends with one
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	tmpl, err := template.New("result").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &templateWriter{w: bufio.NewWriter(w), tmpl: tmpl, sites: siteMap(sites)}, nil
+}
+
+func (w *templateWriter) Write(d sql.Dir) error {
+	site := w.sites[d.Site]
+	hostname := site.Address
+	if host, _, err := net.SplitHostPort(site.Address); err == nil {
+		hostname = host
+	}
+	return w.tmpl.Execute(w.w, templateResult{
+		Dir:      d,
+		Name:     path.Base(d.Path),
+		Parent:   path.Dir(d.Path),
+		Address:  site.Address,
+		Host:     site.Address,
+		Hostname: hostname,
+		Root:     site.Root,
+		URL:      site.URL(d.Path).String(),
+	})
+}
+
+func (w *templateWriter) Flush() error { return w.w.Flush() }
+
+// urlWriter writes the URL of each result on the site it was found on.
+type urlWriter struct {
+	w     *bufio.Writer
+	sites map[string]crawler.Site
+}
+
+func newURLWriter(w io.Writer, sites []crawler.Site) *urlWriter {
+	return &urlWriter{w: bufio.NewWriter(w), sites: siteMap(sites)}
+}
+
+func (w *urlWriter) Write(d sql.Dir) error {
+	site, ok := w.sites[d.Site]
+	if !ok {
+		return fmt.Errorf("site %q of %s is not configured", d.Site, d.Path)
+	}
+	_, err := fmt.Fprintln(w.w, site.URL(d.Path))
+	return err
+}
+
+func (w *urlWriter) Flush() error { return w.w.Flush() }
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mpolden/fs/crawler"
+	"github.com/mpolden/fs/sql"
+)
+
+func TestStructuredWriters(t *testing.T) {
+	dirs := []sql.Dir{
+		{Site: "site1", Path: "/tv/Some Show, Season 1", Modified: 1514764800, Size: 42, NumFiles: 3, FirstSeen: 1514851200, LastSeen: 1514937600},
+		{Site: "site2", Path: "/foo"},
+	}
+	var tests = []struct {
+		format string
+		out    string
+	}{
+		{"json", `[
+{"site":"site1","path":"/tv/Some Show, Season 1","name":"Some Show, Season 1","parent":"/tv","modified":"2018-01-01T00:00:00Z","size":42,"num_files":3,"first_seen":"2018-01-02T00:00:00Z","last_seen":"2018-01-03T00:00:00Z"},
+{"site":"site2","path":"/foo","name":"foo","parent":"/","modified":"1970-01-01T00:00:00Z","size":0,"num_files":0}
+]
+`},
+		{"ndjson", `{"site":"site1","path":"/tv/Some Show, Season 1","name":"Some Show, Season 1","parent":"/tv","modified":"2018-01-01T00:00:00Z","size":42,"num_files":3,"first_seen":"2018-01-02T00:00:00Z","last_seen":"2018-01-03T00:00:00Z"}
+{"site":"site2","path":"/foo","name":"foo","parent":"/","modified":"1970-01-01T00:00:00Z","size":0,"num_files":0}
+`},
+		{"csv", `site,path,name,parent,modified,size,num_files,first_seen,last_seen
+site1,"/tv/Some Show, Season 1","Some Show, Season 1",/tv,2018-01-01T00:00:00Z,42,3,2018-01-02T00:00:00Z,2018-01-03T00:00:00Z
+site2,/foo,foo,/,1970-01-01T00:00:00Z,0,0,,
+`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		var w resultWriter
+		switch tt.format {
+		case "json":
+			w = newJSONWriter(&buf)
+		case "ndjson":
+			w = newNDJSONWriter(&buf)
+		case "csv":
+			w = newCSVWriter(&buf)
+		}
+		for _, d := range dirs {
+			if err := w.Write(d); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.out {
+			t.Errorf("format %s => %q, want %q", tt.format, got, tt.out)
+		}
+	}
+	var buf bytes.Buffer
+	if err := newJSONWriter(&buf).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "[]\n" {
+		t.Errorf("empty json => %q, want %q", got, "[]\n")
+	}
+}
+
+func TestWriteStructuredCounts(t *testing.T) {
+	facets := []sql.Facet{{Value: "2018", Count: 2}, {Value: "2017", Count: 1}}
+	var tests = []struct {
+		format string
+		facet  string
+		facets []sql.Facet
+		out    string
+	}{
+		{"json", "", []sql.Facet{{Count: 3}}, "[\n{\"count\":3}\n]\n"},
+		{"json", "year", facets, "[\n{\"count\":2,\"year\":\"2018\"},\n{\"count\":1,\"year\":\"2017\"}\n]\n"},
+		{"ndjson", "year", facets, "{\"count\":2,\"year\":\"2018\"}\n{\"count\":1,\"year\":\"2017\"}\n"},
+		{"csv", "", []sql.Facet{{Count: 3}}, "count\n3\n"},
+		{"csv", "year", facets, "year,count\n2018,2\n2017,1\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeCounts(&buf, tt.format, tt.facet, tt.facets); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.out {
+			t.Errorf("writeCounts(%q, %q) => %q, want %q", tt.format, tt.facet, got, tt.out)
+		}
+	}
+}
+
+func TestTemplateWriter(t *testing.T) {
+	sites := []crawler.Site{{Name: "site1", Address: "ftp.example.com:2121", Root: "/pub", Password: "secret"}}
+	d := sql.Dir{Site: "site1", Path: "/pub/Some Show/S01 E01", Modified: 1514764800, Size: 2048}
+	var tests = []struct {
+		text string
+		out  string
+	}{
+		{"{{.Site}} ftp://{{.Host}}{{.Path}}", "site1 ftp://ftp.example.com:2121/pub/Some Show/S01 E01\n"},
+		{"{{.Hostname}} {{.Address}} {{.Root}}\n", "ftp.example.com ftp.example.com:2121 /pub\n"},
+		{"ftp://{{.Host}}{{escapepath .Path}}", "ftp://ftp.example.com:2121/pub/Some%20Show/S01%20E01\n"},
+		{"?q={{escapequery .Name}}", "?q=S01+E01\n"},
+		{`{{date "2006-01-02" .Modified}} {{size .Size}}`, "2018-01-01 2.0K\n"},
+		{"{{.Name}} {{.Parent}} {{base .Parent}} {{dir .Parent}} {{ext \"a.mkv\"}}", "S01 E01 /pub/Some Show Some Show /pub .mkv\n"},
+		{`{{join .Parent "extras"}} {{trimprefix .Root .Path}}`, "/pub/Some Show/extras /Some Show/S01 E01\n"},
+		{"{{.URL}}", "ftp://ftp.example.com:2121/pub/Some%20Show/S01%20E01\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w, err := newTemplateWriter(&buf, tt.text, sites)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write(d); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.out {
+			t.Errorf("template %q => %q, want %q", tt.text, got, tt.out)
+		}
+	}
+	var buf bytes.Buffer
+	w, err := newTemplateWriter(&buf, "{{.Password}}", sites)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(d); err == nil {
+		t.Error("want error when template refers to site password")
+	}
+	if _, err := newTemplateWriter(&buf, "{{.Site", sites); err == nil {
+		t.Error("want error for invalid template")
+	}
+}
+
+func TestURLWriter(t *testing.T) {
+	sites := []crawler.Site{
+		{Name: "site1", Address: "ftp.example.com:21", Username: "foo", Password: "secret"},
+		{Name: "site2", Address: "example.org", TLS: true},
+	}
+	var buf bytes.Buffer
+	w := newURLWriter(&buf, sites)
+	for _, d := range []sql.Dir{{Site: "site1", Path: "/pub/Some Show"}, {Site: "site2", Path: "/foo"}} {
+		if err := w.Write(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "ftp://foo@ftp.example.com:21/pub/Some%20Show\nftps://example.org/foo\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := w.Write(sql.Dir{Site: "site3", Path: "/foo"}); err == nil {
+		t.Error("want error for unknown site")
+	}
+}
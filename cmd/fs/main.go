@@ -18,17 +18,76 @@ func main() {
 		log.Fatal(err)
 	}
 	update.Logger = logger
+	var daemon cmd.Daemon
+	if _, err := p.AddCommand("daemon", "Update database on a schedule",
+		"Keeps running and crawls each site on the schedule set in its config", &daemon); err != nil {
+		log.Fatal(err)
+	}
+	daemon.Logger = logger
 	var gc cmd.GC
 	if _, err := p.AddCommand("gc", "Clean database",
 		"Remove entries for sites that do not exist in config", &gc); err != nil {
 		log.Fatal(err)
 	}
 	gc.Logger = logger
+	var db cmd.DB
+	dbCmd, err := p.AddCommand("db", "Manage database",
+		"Manage the database schema", &db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var dbMigrate cmd.DBMigrate
+	if _, err := dbCmd.AddCommand("migrate", "Migrate database",
+		"Apply pending schema migrations", &dbMigrate); err != nil {
+		log.Fatal(err)
+	}
+	var dbReindex cmd.DBReindex
+	if _, err := dbCmd.AddCommand("reindex", "Rebuild search index",
+		"Rebuild the full-text search index from the stored paths", &dbReindex); err != nil {
+		log.Fatal(err)
+	}
 	var search cmd.Search
 	if _, err := p.AddCommand("search", "Search database",
 		"Search database", &search); err != nil {
 		log.Fatal(err)
 	}
+	var newDirs cmd.New
+	if _, err := p.AddCommand("new", "Show new directories",
+		"Show directories first seen since the last invocation", &newDirs); err != nil {
+		log.Fatal(err)
+	}
+	var watch cmd.Watch
+	watchCmd, err := p.AddCommand("watch", "Manage saved searches",
+		"Manage saved searches that are checked for new matches after every update", &watch)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var watchAdd cmd.WatchAdd
+	if _, err := watchCmd.AddCommand("add", "Add saved search",
+		"Add a saved search for the given query", &watchAdd); err != nil {
+		log.Fatal(err)
+	}
+	var watchList cmd.WatchList
+	if _, err := watchCmd.AddCommand("list", "List saved searches",
+		"List saved searches", &watchList); err != nil {
+		log.Fatal(err)
+	}
+	var watchRemove cmd.WatchRemove
+	if _, err := watchCmd.AddCommand("rm", "Remove saved searches",
+		"Remove saved searches with the given IDs", &watchRemove); err != nil {
+		log.Fatal(err)
+	}
+	var serve cmd.Serve
+	if _, err := p.AddCommand("serve", "Serve HTTP API",
+		"Serve a web UI and a read-only JSON API for searching the database over HTTP", &serve); err != nil {
+		log.Fatal(err)
+	}
+	serve.Logger = logger
+	var sites cmd.Sites
+	if _, err := p.AddCommand("sites", "Show site status",
+		"Show when sites were last crawled", &sites); err != nil {
+		log.Fatal(err)
+	}
 	var test cmd.Test
 	if _, err := p.AddCommand("test", "Test configuration",
 		"Test and print configuration", &test); err != nil {
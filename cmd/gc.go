@@ -10,6 +10,7 @@ type GC struct {
 	opts
 	Logger  *log.Logger
 	Dryrun  bool     `short:"n" long:"dry-run" description:"Only show what would be deleted"`
+	Wait    bool     `long:"wait" description:"Wait for another process updating the database to finish instead of failing"`
 	Exclude []string `short:"e" long:"exclude" description:"Exclude sites" value-name:"SITES"`
 }
 
@@ -35,6 +36,11 @@ func (c *GC) Execute(args []string) error {
 		return errUnexpectedArgs
 	}
 	cfg := mustReadConfig(c.Config)
+	lock, err := lockDatabase(cfg.Database, c.Wait, c.Logger)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
 	db, err := sql.New(cfg.Database)
 	if err != nil {
 		return err
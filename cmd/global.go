@@ -1,12 +1,16 @@
 package cmd
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/mpolden/fs/crawler"
+	"github.com/mpolden/fs/sql"
 )
 
 var errUnexpectedArgs = errors.New("command does not accept any arguments")
@@ -16,6 +20,63 @@ type opts struct {
 	Config string `short:"f" long:"config" description:"Config file" value-name:"FILE" default:"~/.fsrc"`
 }
 
+// isTerminal returns whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// openDatabase opens the database in filename without migrating it, for commands that do not hold the database lock.
+// Migrations must be applied by a command holding the lock, such as fs db migrate.
+func openDatabase(filename string) (*sql.Client, error) {
+	db, err := sql.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) > 0 {
+		return nil, fmt.Errorf("%s: database has pending migrations, run fs db migrate", filename)
+	}
+	return db, nil
+}
+
+// lockPollInterval is the interval between attempts to acquire a database lock held by another process.
+var lockPollInterval = time.Second
+
+// lockDatabase acquires the lock of the database in filename, which must be held while writing to it. If wait is
+// true, it blocks until the process holding the lock has released it.
+func lockDatabase(filename string, wait bool, logger *log.Logger) (*sql.Lock, error) {
+	return lockDatabaseContext(context.Background(), filename, wait, logger)
+}
+
+// lockDatabaseContext is like lockDatabase. Waiting for the lock stops when ctx is done.
+func lockDatabaseContext(ctx context.Context, filename string, wait bool, logger *log.Logger) (*sql.Lock, error) {
+	for waiting := false; ; waiting = true {
+		lock, err := sql.AcquireLock(filename)
+		locked, ok := err.(*sql.LockedError)
+		if !ok {
+			return lock, err
+		}
+		if !wait {
+			return nil, fmt.Errorf("%s: another fs process may be updating the database, use --wait to wait for it", err)
+		}
+		if !waiting {
+			logger.Printf("Waiting for process %d to release %s", locked.PID, locked.Name)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
 func mustReadConfig(name string) crawler.Config {
 	if name == "~/.fsrc" {
 		home := os.Getenv("HOME")
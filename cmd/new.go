@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/mpolden/fs/sql"
+)
+
+// defaultNewSince is how far back to look when the user has never checked for new directories.
+const defaultNewSince = 24 * time.Hour
+
+type New struct {
+	opts
+	formatOpts
+	Site     string `short:"s" long:"site" description:"Show new directories for a specific site" value-name:"NAME"`
+	Limit    int    `short:"c" long:"max-count" description:"Maximum number of results to show"`
+	Since    string `long:"since" description:"Show directories first seen since this date or duration, e.g. 2018-01-01 or 7d. (default: time of last invocation)" value-name:"TIME"`
+	User     string `short:"u" long:"user" description:"User whose last invocation is tracked. (default: current user)" value-name:"NAME"`
+	NoUpdate bool   `short:"n" long:"no-update" description:"Do not record this invocation"`
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// newSince returns the time to show new directories since. If since is empty, the last checkpoint of user is used.
+func newSince(db *sql.Client, user, since string, now time.Time) (int64, error) {
+	if since != "" {
+		t, err := parseSince(since, now)
+		if err != nil {
+			return 0, err
+		}
+		return t.Unix(), nil
+	}
+	checked, err := db.Checkpoint(user)
+	if err != nil {
+		return 0, err
+	}
+	if checked == 0 {
+		return now.Add(-defaultNewSince).Unix(), nil
+	}
+	return checked, nil
+}
+
+func (c *New) Execute(args []string) error {
+	if len(args) != 0 {
+		return errUnexpectedArgs
+	}
+	cfg := mustReadConfig(c.Config)
+	db, err := openDatabase(cfg.Database)
+	if err != nil {
+		return err
+	}
+	w, err := c.resultWriter(os.Stdout, cfg.Sites)
+	if err != nil {
+		return err
+	}
+	user := c.User
+	if user == "" {
+		user = currentUser()
+	}
+	return c.showNew(db, w, user, time.Now())
+}
+
+// showNew writes the new directories for user to w. The checkpoint of user is only moved once every directory since
+// the previous one has been written.
+func (c *New) showNew(db *sql.Client, w resultWriter, user string, now time.Time) error {
+	since, err := newSince(db, user, c.Since, now)
+	if err != nil {
+		return err
+	}
+	checkpoint, err := db.NextCheckpoint(since)
+	if err != nil {
+		return err
+	}
+	// Fetch an extra result to tell if the output is truncated
+	limit := c.Limit
+	if limit > 0 {
+		limit++
+	}
+	dirs, err := db.SelectDirs(sql.Query{
+		Site:  c.Site,
+		Since: since,
+		Order: []sql.Order{{Field: "site"}, {Field: "first_seen", Descending: true}, {Field: "path"}},
+		Limit: limit,
+	})
+	if err != nil {
+		return err
+	}
+	truncated := c.Limit > 0 && len(dirs) > c.Limit
+	if truncated {
+		dirs = dirs[:c.Limit]
+	}
+	// Only move the checkpoint when showing directories since the last invocation
+	update := c.Since == "" && !c.NoUpdate && !truncated
+	if len(dirs) == 0 {
+		if update {
+			if err := db.SetCheckpoint(user, checkpoint); err != nil {
+				return err
+			}
+		}
+		return fmt.Errorf("no new directories since %s", formatTime(since))
+	}
+	for _, d := range dirs {
+		if err := w.Write(d); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if update {
+		return db.SetCheckpoint(user, checkpoint)
+	}
+	return nil
+}
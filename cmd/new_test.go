@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mpolden/fs/sql"
+)
+
+func TestNewExecute(t *testing.T) {
+	err := (&New{}).Execute([]string{"foo"})
+	if err != errUnexpectedArgs {
+		t.Errorf("Expected error: %s", errUnexpectedArgs)
+	}
+}
+
+func TestNewResultWriter(t *testing.T) {
+	for _, o := range []formatOpts{
+		{Format: "url"},
+		{Template: "{{.Site}} {{.URL}}"},
+		{Format: "csv"},
+	} {
+		c := New{formatOpts: o}
+		if _, err := c.resultWriter(os.Stdout, nil); err != nil {
+			t.Errorf("resultWriter(%+v) = %v, want no error", o, err)
+		}
+	}
+	c := New{formatOpts: formatOpts{Format: "template"}}
+	if _, err := c.resultWriter(os.Stdout, nil); err == nil {
+		t.Error("want error for template format without template")
+	}
+}
+
+func TestNewSince(t *testing.T) {
+	db, err := sql.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetCheckpoint("foo", 42); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2018, 9, 1, 12, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		user  string
+		since string
+		out   int64
+	}{
+		{"foo", "", 42},
+		{"bar", "", now.Add(-24 * time.Hour).Unix()},
+		{"foo", "2h", now.Add(-2 * time.Hour).Unix()},
+		{"foo", "2018-08-01", time.Date(2018, 8, 1, 0, 0, 0, 0, time.UTC).Unix()},
+	}
+	for _, tt := range tests {
+		got, err := newSince(db, tt.user, tt.since, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.out {
+			t.Errorf("newSince(%q, %q) => %d, want %d", tt.user, tt.since, got, tt.out)
+		}
+	}
+}
+
+type recordingWriter struct {
+	dirs []sql.Dir
+	err  error
+}
+
+func (w *recordingWriter) Write(d sql.Dir) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.dirs = append(w.dirs, d)
+	return nil
+}
+
+func (w *recordingWriter) Flush() error { return nil }
+
+func TestShowNew(t *testing.T) {
+	db, err := sql.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("site1", []sql.Dir{{Path: "/a"}, {Path: "/b"}, {Path: "/c"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint := func() int64 {
+		checked, err := db.Checkpoint("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return checked
+	}
+	now := time.Now()
+	// Failed and truncated output keeps the checkpoint
+	if err := (&New{}).showNew(db, &recordingWriter{err: errors.New("write failed")}, "foo", now); err == nil {
+		t.Error("want error when writing fails")
+	}
+	if got := checkpoint(); got != 0 {
+		t.Errorf("got checkpoint %d after failed write, want 0", got)
+	}
+	w := &recordingWriter{}
+	if err := (&New{Limit: 2}).showNew(db, w, "foo", now); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.dirs) != 2 {
+		t.Errorf("got %d dirs, want 2", len(w.dirs))
+	}
+	if got := checkpoint(); got != 0 {
+		t.Errorf("got checkpoint %d after truncated output, want 0", got)
+	}
+	w = &recordingWriter{}
+	if err := (&New{Limit: 3}).showNew(db, w, "foo", now); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.dirs) != 3 {
+		t.Errorf("got %d dirs, want 3", len(w.dirs))
+	}
+	if got := checkpoint(); got <= w.dirs[0].FirstSeen {
+		t.Errorf("got checkpoint %d, want after first seen %d", got, w.dirs[0].FirstSeen)
+	}
+	if err := (&New{}).showNew(db, &recordingWriter{}, "foo", now); err == nil {
+		t.Error("want error for no new directories")
+	}
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mpolden/fs/crawler"
+)
+
+// liveInterval is the interval between redraws of a live progress display.
+const liveInterval = 200 * time.Millisecond
+
+// progressDisplay displays the progress of crawls. A live display redraws the progress of all sites in place, and
+// prints anything written to it above the progress. Otherwise progress is logged periodically.
+type progressDisplay struct {
+	mu       sync.Mutex
+	w        io.Writer
+	logger   *log.Logger
+	live     bool
+	sites    []string
+	progress map[string]crawler.Progress
+	drawn    int
+}
+
+func newProgressDisplay(w io.Writer, logger *log.Logger, live bool) *progressDisplay {
+	return &progressDisplay{
+		w:        w,
+		logger:   logger,
+		live:     live,
+		progress: make(map[string]crawler.Progress),
+	}
+}
+
+func (d *progressDisplay) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clear()
+	n, err := d.w.Write(p)
+	d.draw()
+	return n, err
+}
+
+func (d *progressDisplay) update(p crawler.Progress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.progress[p.Site]; !ok {
+		d.sites = append(d.sites, p.Site)
+	}
+	d.progress[p.Site] = p
+	if p.Done && !d.live {
+		d.logger.Print(p)
+	}
+}
+
+func (d *progressDisplay) clear() {
+	for ; d.drawn > 0; d.drawn-- {
+		// Move cursor up and erase line
+		fmt.Fprint(d.w, "\x1b[1A\x1b[2K")
+	}
+}
+
+func (d *progressDisplay) draw() {
+	if !d.live {
+		return
+	}
+	for _, site := range d.sites {
+		fmt.Fprintln(d.w, d.progress[site])
+		d.drawn++
+	}
+}
+
+func (d *progressDisplay) refresh() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.live {
+		d.clear()
+		d.draw()
+		return
+	}
+	for _, site := range d.sites {
+		if p := d.progress[site]; !p.Done {
+			d.logger.Print(p)
+		}
+	}
+}
+
+// run displays progress received on ch until ch is closed. Progress is logged every interval, unless the display is
+// live.
+func (d *progressDisplay) run(ch <-chan crawler.Progress, interval time.Duration) {
+	if d.live {
+		interval = liveInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case p, ok := <-ch:
+			if !ok {
+				d.mu.Lock()
+				defer d.mu.Unlock()
+				d.clear()
+				d.draw()
+				// Leave the final progress on screen
+				d.drawn = 0
+				return
+			}
+			d.update(p)
+		case <-ticker.C:
+			d.refresh()
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"bytes"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/mpolden/fs/crawler"
+)
+
+func TestProgressDisplayLive(t *testing.T) {
+	var buf bytes.Buffer
+	d := newProgressDisplay(&buf, nil, true)
+	d.update(crawler.Progress{Site: "foo", Phase: crawler.PhaseWalking})
+	d.refresh()
+	want := "[foo] walking: 0 listed, 0 queued, 0 indexed, 0 errors, 0s elapsed, 0.0 dirs/s\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	buf.Reset()
+	logger := log.New(d, "", 0)
+	logger.Print("bar")
+	// Progress is erased, and redrawn below the log line
+	want = "\x1b[1A\x1b[2Kbar\n[foo] walking: 0 listed, 0 queued, 0 indexed, 0 errors, 0s elapsed, 0.0 dirs/s\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestProgressDisplayLog(t *testing.T) {
+	var buf bytes.Buffer
+	d := newProgressDisplay(nil, log.New(&buf, "", 0), false)
+	ch := make(chan crawler.Progress)
+	done := make(chan bool)
+	go func() {
+		d.run(ch, time.Hour)
+		close(done)
+	}()
+	ch <- crawler.Progress{Site: "foo", Phase: crawler.PhaseWalking}
+	ch <- crawler.Progress{Site: "foo", Phase: crawler.PhaseDone, Done: true}
+	close(ch)
+	<-done
+	// Only the final progress is logged before the interval has passed
+	want := "[foo] done: 0 listed, 0 queued, 0 indexed, 0 errors, 0s elapsed, 0.0 dirs/s\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -1,90 +1,432 @@
 package cmd
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
 
+	"github.com/mpolden/fs/crawler"
 	"github.com/mpolden/fs/sql"
 	"github.com/olekukonko/tablewriter"
 )
 
+// formatOpts are the options for writing results, shared by the commands showing directories.
+type formatOpts struct {
+	Format       string `short:"F" long:"format" description:"Format to use when printing results. (default: table, when piping: path)" choice:"table" choice:"simple" choice:"path" choice:"json" choice:"ndjson" choice:"csv" choice:"url" choice:"template"`
+	Template     string `long:"template" description:"Go template to write each result with when using the template format, e.g. '{{.Site}} ftp://{{.Host}}{{.Path}}'" value-name:"TEMPLATE"`
+	TemplateFile string `long:"template-file" description:"File containing the template to write each result with" value-name:"FILE"`
+}
+
 type Search struct {
 	opts
-	Site   string   `short:"s" long:"site" description:"Search a specific site" value-name:"NAME"`
-	Limit  int      `short:"c" long:"max-count" description:"Maximum number of results to show"`
-	Format string   `short:"F" long:"format" description:"Format to use when printing results. (default: table, when piping: path)" choice:"table" choice:"simple" choice:"path"`
-	Order  []string `short:"o" long:"order" description:"Columns to sort results by" value-name:"COLUMN" default:"site:asc" default:"dir.path:asc"`
+	formatOpts
+	Site    string   `short:"s" long:"site" description:"Search a specific site" value-name:"NAME"`
+	Limit   int      `short:"c" long:"max-count" description:"Maximum number of results to show"`
+	Offset  int      `long:"offset" description:"Number of results to skip" value-name:"N"`
+	Page    int      `short:"p" long:"page" description:"Page of results to show, where each page has --max-count results" value-name:"N"`
+	Order   []string `short:"o" long:"order" description:"Field to sort results by: site, path, modified, name, depth, size or rank, optionally followed by :asc or :desc and :nulls-first or :nulls-last. Use rank to sort by relevance" value-name:"FIELD" default:"site:asc" default:"path:asc"`
+	Type    string   `short:"t" long:"type" description:"Type of entries to search" choice:"dir" choice:"file" default:"dir"`
+	MinSize string   `long:"min-size" description:"Only show results of at least this size, e.g. 700M" value-name:"SIZE"`
+	MaxSize string   `long:"max-size" description:"Only show results of at most this size, e.g. 4G" value-name:"SIZE"`
+	Since   string   `long:"since" description:"Only show directories first seen since this date or duration, e.g. 2018-01-01 or 7d" value-name:"TIME"`
+	New     bool     `long:"new" description:"Only show directories first seen in the last update of their site"`
+	Count   bool     `long:"count" description:"Show the number of results instead of the results"`
+	Facet   string   `long:"facet" description:"Show the number of results for each value of a field" choice:"site" choice:"year" choice:"month" choice:"toplevel"`
 }
 
-func writeTable(w io.Writer, dirs []sql.Dir) error {
-	table := tablewriter.NewWriter(w)
-	table.SetHeader([]string{"Site", "Path", "Date"})
-	for _, d := range dirs {
-		date := time.Unix(d.Modified, 0).UTC().Format("2006-01-02")
-		row := []string{d.Site, d.Path, date}
-		table.Append(row)
+var sizeUnits = []string{"B", "K", "M", "G", "T", "P"}
+
+// parseSize parses a size in bytes, optionally followed by a binary unit.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
 	}
-	table.Render()
+	n := strings.ToUpper(strings.TrimSuffix(strings.TrimSuffix(s, "B"), "b"))
+	multiplier := int64(1)
+	for i := len(sizeUnits) - 1; i > 0; i-- {
+		if strings.HasSuffix(n, sizeUnits[i]) {
+			n = strings.TrimSuffix(n, sizeUnits[i])
+			multiplier = 1 << uint(10*i)
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return int64(f * float64(multiplier)), nil
+}
+
+// parseSince parses s as either a date or a duration before now. Durations may be given in days (d) or weeks (w).
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	var d time.Duration
+	unit := s[len(s)-1]
+	if unit == 'd' || unit == 'w' {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time: %q", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+		if unit == 'w' {
+			d *= 7
+		}
+	} else {
+		var err error
+		d, err = time.ParseDuration(s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time: %q", s)
+		}
+	}
+	return now.Add(-d), nil
+}
+
+func formatDate(t int64) string {
+	if t == 0 {
+		return ""
+	}
+	return time.Unix(t, 0).UTC().Format("2006-01-02")
+}
+
+func formatSize(size int64) string {
+	f := float64(size)
+	i := 0
+	for f >= 1024 && i < len(sizeUnits)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%s", size, sizeUnits[i])
+	}
+	return fmt.Sprintf("%.1f%s", f, sizeUnits[i])
+}
+
+// resultWriter writes search results in some format. Results are written as they are read from the database, and
+// Flush is called after the last one.
+type resultWriter interface {
+	Write(d sql.Dir) error
+	Flush() error
+}
+
+// tableWriter writes results as a table. The table is only rendered on Flush, as column widths depend on every row.
+type tableWriter struct{ table *tablewriter.Table }
+
+func newTableWriter(w io.Writer) *tableWriter {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Site", "Path", "Date", "Size", "Files", "First seen"})
+	return &tableWriter{table: table}
+}
+
+func (w *tableWriter) Write(d sql.Dir) error {
+	date := time.Unix(d.Modified, 0).UTC().Format("2006-01-02")
+	w.table.Append([]string{d.Site, d.Path, date, formatSize(d.Size), strconv.Itoa(d.NumFiles), formatDate(d.FirstSeen)})
+	return nil
+}
+
+func (w *tableWriter) Flush() error {
+	w.table.Render()
 	return nil
 }
 
-func writeSimple(w io.Writer, dirs []sql.Dir) error {
+type simpleWriter struct{ tab *tabwriter.Writer }
+
+func newSimpleWriter(w io.Writer) *simpleWriter {
 	tab := tabwriter.NewWriter(w, 0, 8, 0, '\t', 0)
-	fmt.Fprintln(tab, "SITE\tPATH\tDATE")
-	for _, d := range dirs {
-		fmt.Fprintf(tab, "%s\t%s\t%d\n", d.Site, d.Path, d.Modified)
+	fmt.Fprintln(tab, "SITE\tPATH\tDATE\tSIZE\tFILES\tFIRST_SEEN")
+	return &simpleWriter{tab: tab}
+}
+
+func (w *simpleWriter) Write(d sql.Dir) error {
+	_, err := fmt.Fprintf(w.tab, "%s\t%s\t%d\t%d\t%d\t%d\n", d.Site, d.Path, d.Modified, d.Size, d.NumFiles, d.FirstSeen)
+	return err
+}
+
+func (w *simpleWriter) Flush() error { return w.tab.Flush() }
+
+type pathWriter struct{ w *bufio.Writer }
+
+func newPathWriter(w io.Writer) *pathWriter { return &pathWriter{w: bufio.NewWriter(w)} }
+
+func (w *pathWriter) Write(d sql.Dir) error {
+	_, err := fmt.Fprintf(w.w, "%s %s\n", d.Site, d.Path)
+	return err
+}
+
+func (w *pathWriter) Flush() error { return w.w.Flush() }
+
+// outputFormat returns the format to write results to f in.
+func outputFormat(f *os.File, format string) string {
+	// Default to path format if ouput is being piped
+	if format == "" && !isTerminal(f) {
+		return "path"
+	}
+	return format
+}
+
+func newResultWriter(f *os.File, format string) resultWriter {
+	switch outputFormat(f, format) {
+	case "simple":
+		return newSimpleWriter(f)
+	case "path":
+		return newPathWriter(f)
+	case "json":
+		return newJSONWriter(f)
+	case "ndjson":
+		return newNDJSONWriter(f)
+	case "csv":
+		return newCSVWriter(f)
 	}
-	return tab.Flush()
+	return newTableWriter(f)
 }
 
-func writePath(w io.Writer, dirs []sql.Dir) error {
-	for _, d := range dirs {
-		fmt.Fprintf(w, "%s %s\n", d.Site, d.Path)
+// writeCounts writes the number of results for each value of facet. If facet is empty, facets holds a single count of
+// all results.
+func writeCounts(w io.Writer, format, facet string, facets []sql.Facet) error {
+	switch format {
+	case "json", "ndjson", "csv":
+		return writeStructuredCounts(w, format, facet, facets)
+	case "simple":
+		tab := tabwriter.NewWriter(w, 0, 8, 0, '\t', 0)
+		if facet == "" {
+			fmt.Fprintln(tab, "COUNT")
+		} else {
+			fmt.Fprintf(tab, "%s\tCOUNT\n", strings.ToUpper(facet))
+		}
+		for _, fc := range facets {
+			if facet != "" {
+				fmt.Fprintf(tab, "%s\t", fc.Value)
+			}
+			fmt.Fprintf(tab, "%d\n", fc.Count)
+		}
+		return tab.Flush()
+	case "path":
+		for _, fc := range facets {
+			if facet != "" {
+				fmt.Fprintf(w, "%s ", fc.Value)
+			}
+			fmt.Fprintf(w, "%d\n", fc.Count)
+		}
+		return nil
+	}
+	table := tablewriter.NewWriter(w)
+	if facet == "" {
+		table.SetHeader([]string{"Count"})
+	} else {
+		table.SetHeader([]string{facet, "Count"})
 	}
+	for _, fc := range facets {
+		count := strconv.FormatInt(fc.Count, 10)
+		if facet == "" {
+			table.Append([]string{count})
+		} else {
+			table.Append([]string{fc.Value, count})
+		}
+	}
+	table.Render()
 	return nil
 }
 
-func writeResults(f *os.File, format string, dirs []sql.Dir) error {
-	if format == "" {
-		stat, err := f.Stat()
+// format returns the format given by o, which is template if only a template is given.
+func (o *formatOpts) format() (string, error) {
+	hasTemplate := o.Template != "" || o.TemplateFile != ""
+	if o.Format == "" && hasTemplate {
+		return "template", nil
+	}
+	if o.Format != "template" && hasTemplate {
+		return "", errors.New("--template and --template-file require --format template")
+	}
+	return o.Format, nil
+}
+
+// resultWriter returns the writer of results to f in the format of o. sites are exposed to templates.
+func (o *formatOpts) resultWriter(f *os.File, sites []crawler.Site) (resultWriter, error) {
+	format, err := o.format()
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "url":
+		return newURLWriter(f, sites), nil
+	case "template":
+		return o.templateWriter(f, sites)
+	}
+	return newResultWriter(f, format), nil
+}
+
+// templateWriter returns the writer of results using the template of o.
+func (o *formatOpts) templateWriter(f *os.File, sites []crawler.Site) (resultWriter, error) {
+	text := o.Template
+	if o.TemplateFile != "" {
+		if o.Template != "" {
+			return nil, errors.New("--template and --template-file cannot be combined")
+		}
+		b, err := ioutil.ReadFile(o.TemplateFile)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		// Default to path format if ouput is being piped
-		if stat.Mode()&os.ModeCharDevice == 0 {
-			format = "path"
+		text = string(b)
+	}
+	if text == "" {
+		return nil, errors.New("--format template requires --template or --template-file")
+	}
+	return newTemplateWriter(f, text, sites)
+}
+
+// resultWriter returns the writer of results to f in the format of c.
+func (c *Search) resultWriter(f *os.File, sites []crawler.Site) (resultWriter, error) {
+	format, err := c.format()
+	if err != nil {
+		return nil, err
+	}
+	if (format == "template" || format == "url") && (c.Count || c.Facet != "") {
+		return nil, fmt.Errorf("--format %s cannot be used with --count or --facet", format)
+	}
+	return c.formatOpts.resultWriter(f, sites)
+}
+
+// counts returns the number of results of q per value of the facet of c, or in total if c has no facet.
+func (c *Search) counts(db *sql.Client, q sql.Query) ([]sql.Facet, error) {
+	if c.Facet != "" {
+		if c.Type == "file" {
+			return db.FacetFiles(q, c.Facet)
 		}
+		return db.FacetDirs(q, c.Facet)
 	}
-	switch format {
-	case "simple":
-		return writeSimple(f, dirs)
-	case "path":
-		return writePath(f, dirs)
+	var (
+		count int64
+		err   error
+	)
+	if c.Type == "file" {
+		count, err = db.CountMatchingFiles(q)
+	} else {
+		count, err = db.CountMatchingDirs(q)
+	}
+	return []sql.Facet{{Count: count}}, err
+}
+
+// scanDir reads the current result of rows. files is whether rows are the results of a search for files.
+func scanDir(rows *sql.Rows, files bool) (sql.Dir, error) {
+	if files {
+		var f sql.File
+		err := rows.Scan(&f)
+		return f.Dir, err
+	}
+	var d sql.Dir
+	err := rows.Scan(&d)
+	return d, err
+}
+
+// pageOffset returns the number of results to skip to show page, where each page has limit results.
+func pageOffset(offset, page, limit int) (int, error) {
+	if page == 0 {
+		return offset, nil
+	}
+	if offset != 0 {
+		return 0, errors.New("--offset and --page cannot be combined")
+	}
+	if limit <= 0 {
+		return 0, errors.New("--page requires --max-count")
+	}
+	if page < 0 {
+		return 0, fmt.Errorf("invalid page: %d", page)
 	}
-	return writeTable(f, dirs)
+	return (page - 1) * limit, nil
 }
 
 func (c *Search) Execute(args []string) error {
 	cfg := mustReadConfig(c.Config)
-	db, err := sql.New(cfg.Database)
+	db, err := openDatabase(cfg.Database)
 	if err != nil {
 		return err
 	}
-	order, err := sql.OrderByClauses(c.Order)
+	order, err := sql.ParseOrders(c.Order)
 	if err != nil {
 		return err
 	}
-	keywords := strings.Join(args, " ")
-	dirs, err := db.SelectDirs(keywords, c.Site, order, c.Limit)
+	w, err := c.resultWriter(os.Stdout, cfg.Sites)
 	if err != nil {
 		return err
 	}
-	if len(dirs) == 0 {
+	offset, err := pageOffset(c.Offset, c.Page, c.Limit)
+	if err != nil {
+		return err
+	}
+	minSize, err := parseSize(c.MinSize)
+	if err != nil {
+		return err
+	}
+	maxSize, err := parseSize(c.MaxSize)
+	if err != nil {
+		return err
+	}
+	since, err := parseSince(c.Since, time.Now())
+	if err != nil {
+		return err
+	}
+	if c.Type == "file" && (c.Since != "" || c.New) {
+		return errors.New("--since and --new cannot be used with --type file")
+	}
+	q := sql.Query{
+		Keywords: strings.Join(args, " "),
+		Site:     c.Site,
+		MinSize:  minSize,
+		MaxSize:  maxSize,
+		New:      c.New,
+		Order:    order,
+		Limit:    c.Limit,
+		Offset:   offset,
+	}
+	if !since.IsZero() {
+		q.Since = since.Unix()
+	}
+	if c.Count || c.Facet != "" {
+		if c.Count && c.Facet != "" {
+			return errors.New("--count and --facet cannot be combined")
+		}
+		counts, err := c.counts(db, q)
+		if err != nil {
+			return err
+		}
+		return writeCounts(os.Stdout, outputFormat(os.Stdout, c.Format), c.Facet, counts)
+	}
+	var rows *sql.Rows
+	if c.Type == "file" {
+		rows, err = db.QueryFiles(q)
+	} else {
+		rows, err = db.QueryDirs(q)
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		d, err := scanDir(rows, c.Type == "file")
+		if err != nil {
+			return err
+		}
+		if err := w.Write(d); err != nil {
+			return err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if n == 0 {
 		return fmt.Errorf("no results found")
 	}
-	return writeResults(os.Stdout, c.Format, dirs)
+	return w.Flush()
 }
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mpolden/fs/sql"
+)
+
+func TestParseSize(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out int64
+		err bool
+	}{
+		{"", 0, false},
+		{"42", 42, false},
+		{"42B", 42, false},
+		{"1K", 1024, false},
+		{"1.5k", 1536, false},
+		{"700M", 700 << 20, false},
+		{"4GB", 4 << 30, false},
+		{"1T", 1 << 40, false},
+		{"foo", 0, true},
+		{"-1M", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("parseSize(%q) => error %v, want error=%t", tt.in, err, tt.err)
+		}
+		if got != tt.out {
+			t.Errorf("parseSize(%q) => %d, want %d", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	var tests = []struct {
+		in  int64
+		out string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.0K"},
+		{1536, "1.5K"},
+		{700 << 20, "700.0M"},
+		{4 << 30, "4.0G"},
+	}
+	for _, tt := range tests {
+		if got := formatSize(tt.in); got != tt.out {
+			t.Errorf("formatSize(%d) => %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2018, 9, 15, 12, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		in  string
+		out time.Time
+		err bool
+	}{
+		{"", time.Time{}, false},
+		{"2018-01-01", time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"7d", time.Date(2018, 9, 8, 12, 0, 0, 0, time.UTC), false},
+		{"2w", time.Date(2018, 9, 1, 12, 0, 0, 0, time.UTC), false},
+		{"36h", time.Date(2018, 9, 14, 0, 0, 0, 0, time.UTC), false},
+		{"xd", time.Time{}, true},
+		{"foo", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.in, now)
+		if (err != nil) != tt.err {
+			t.Errorf("parseSince(%q) => error %v, want error=%t", tt.in, err, tt.err)
+		}
+		if !got.Equal(tt.out) {
+			t.Errorf("parseSince(%q) => %s, want %s", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestPageOffset(t *testing.T) {
+	var tests = []struct {
+		offset, page, limit int
+		out                 int
+		err                 bool
+	}{
+		{0, 0, 0, 0, false},
+		{5, 0, 0, 5, false},
+		{0, 1, 10, 0, false},
+		{0, 3, 10, 20, false},
+		{0, 2, 0, 0, true},
+		{5, 2, 10, 0, true},
+		{0, -1, 10, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := pageOffset(tt.offset, tt.page, tt.limit)
+		if (err != nil) != tt.err {
+			t.Errorf("pageOffset(%d, %d, %d) = %v, want error %t", tt.offset, tt.page, tt.limit, err, tt.err)
+		}
+		if got != tt.out {
+			t.Errorf("pageOffset(%d, %d, %d) => %d, want %d", tt.offset, tt.page, tt.limit, got, tt.out)
+		}
+	}
+}
+
+func TestResultWriters(t *testing.T) {
+	dirs := []sql.Dir{{Site: "site1", Path: "/foo", Modified: 1, Size: 2, NumFiles: 3, FirstSeen: 4}, {Site: "site2", Path: "/bar"}}
+	var tests = []struct {
+		newWriter func(io.Writer) resultWriter
+		out       string
+	}{
+		{func(w io.Writer) resultWriter { return newPathWriter(w) }, "site1 /foo\nsite2 /bar\n"},
+		{func(w io.Writer) resultWriter { return newSimpleWriter(w) }, "SITE\tPATH\tDATE\tSIZE\tFILES\tFIRST_SEEN\nsite1\t/foo\t1\t2\t3\t4\nsite2\t/bar\t0\t0\t0\t0\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w := tt.newWriter(&buf)
+		for _, d := range dirs {
+			if err := w.Write(d); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.out {
+			t.Errorf("got %q, want %q", got, tt.out)
+		}
+	}
+}
+
+func TestWriteCounts(t *testing.T) {
+	facets := []sql.Facet{{Value: "site1", Count: 2}, {Value: "site2", Count: 1}}
+	var tests = []struct {
+		format string
+		facet  string
+		facets []sql.Facet
+		out    string
+	}{
+		{"path", "", []sql.Facet{{Count: 3}}, "3\n"},
+		{"path", "site", facets, "site1 2\nsite2 1\n"},
+		{"simple", "", []sql.Facet{{Count: 3}}, "COUNT\n3\n"},
+		{"simple", "site", facets, "SITE\tCOUNT\nsite1\t2\nsite2\t1\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeCounts(&buf, tt.format, tt.facet, tt.facets); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.out {
+			t.Errorf("writeCounts(%q, %q) => %q, want %q", tt.format, tt.facet, got, tt.out)
+		}
+	}
+	var buf bytes.Buffer
+	if err := writeCounts(&buf, "table", "site", facets); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.Contains(out, "SITE") || !strings.Contains(out, "site1") {
+		t.Errorf("writeCounts(table) => %q, want table of sites", out)
+	}
+}
+
+func TestSearchResultWriter(t *testing.T) {
+	var tests = []struct {
+		search Search
+		err    string
+	}{
+		{Search{formatOpts: formatOpts{Format: "json"}}, ""},
+		{Search{formatOpts: formatOpts{Template: "{{.Path}}"}}, ""},
+		{Search{formatOpts: formatOpts{Format: "template", Template: "{{.Path}}"}}, ""},
+		{Search{formatOpts: formatOpts{Format: "template"}}, "--format template requires --template or --template-file"},
+		{Search{formatOpts: formatOpts{Format: "json", Template: "{{.Path}}"}}, "--template and --template-file require --format template"},
+		{Search{formatOpts: formatOpts{Template: "{{.Path}}", TemplateFile: "foo"}}, "--template and --template-file cannot be combined"},
+		{Search{formatOpts: formatOpts{Template: "{{.Path}}"}, Count: true}, "--format template cannot be used with --count or --facet"},
+		{Search{formatOpts: formatOpts{Format: "url"}}, ""},
+		{Search{formatOpts: formatOpts{Format: "url"}, Facet: "site"}, "--format url cannot be used with --count or --facet"},
+	}
+	for _, tt := range tests {
+		_, err := tt.search.resultWriter(os.Stdout, nil)
+		if tt.err == "" && err != nil {
+			t.Errorf("resultWriter(%+v) = %v, want no error", tt.search, err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("resultWriter(%+v) = %v, want %q", tt.search, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mpolden/fs/crawler"
+	"github.com/mpolden/fs/sql"
+)
+
+// defaultListen is the address fs serve listens on when none is configured.
+const defaultListen = ":8080"
+
+// Limits on the number of results returned by the API.
+const (
+	defaultAPILimit = 100
+	maxAPILimit     = 1000
+)
+
+type Serve struct {
+	opts
+	Logger *log.Logger
+	Listen string        `short:"l" long:"listen" description:"Address to listen on. (default: Listen in the Server section of the config, or :8080)" value-name:"ADDR"`
+	MaxAge time.Duration `short:"a" long:"max-age" description:"Consider sites stale when their last successful crawl is older than this" value-name:"DURATION" default:"24h"`
+}
+
+// server serves the HTTP API. The database is only read.
+type server struct {
+	db      *sql.Client
+	sites   []crawler.Site
+	config  crawler.Server
+	timeout time.Duration
+	maxAge  time.Duration
+	logger  *log.Logger
+	now     func() time.Time
+}
+
+// requestError is an error caused by an invalid request.
+type requestError struct{ err error }
+
+func (e *requestError) Error() string { return e.err.Error() }
+
+func badRequest(format string, args ...interface{}) error {
+	return &requestError{fmt.Errorf(format, args...)}
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type resultsResponse struct {
+	Results []sql.Result `json:"results"`
+}
+
+type crawlResponse struct {
+	Started   string `json:"started"`
+	Finished  string `json:"finished"`
+	NumDirs   int    `json:"num_dirs"`
+	Delta     int    `json:"delta"`
+	NumErrors int    `json:"num_errors"`
+	Error     string `json:"error,omitempty"`
+}
+
+type siteResponse struct {
+	Name        string         `json:"name"`
+	Status      string         `json:"status"`
+	LastSuccess *crawlResponse `json:"last_success"`
+	LastFailure *crawlResponse `json:"last_failure"`
+}
+
+type sitesResponse struct {
+	Sites []siteResponse `json:"sites"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// apiFunc handles an API request, returning the value to write as JSON.
+type apiFunc func(r *http.Request) (interface{}, error)
+
+func (s *server) api(fn apiFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"method not allowed"})
+			return
+		}
+		v, err := fn(r)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var reqErr *requestError
+			var syntaxErr *sql.SyntaxError
+			if errors.As(err, &reqErr) || errors.As(err, &syntaxErr) {
+				status = http.StatusBadRequest
+			} else {
+				s.logger.Printf("%s %s: %s", r.Method, r.URL, err)
+			}
+			writeJSON(w, status, errorResponse{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+// equal compares a and b in constant time.
+func equal(a, b string) bool { return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1 }
+
+func (s *server) authorized(r *http.Request) bool {
+	if s.config.Token != "" {
+		auth := r.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") && equal(strings.TrimPrefix(auth, "Bearer "), s.config.Token) {
+			return true
+		}
+	}
+	if s.config.Username != "" {
+		username, password, ok := r.BasicAuth()
+		if ok && equal(username, s.config.Username) && equal(password, s.config.Password) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate wraps next with the authentication configured for s, if any.
+func (s *server) authenticate(next http.Handler) http.Handler {
+	if s.config.Username == "" && s.config.Token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.authorized(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.config.Username != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fs"`)
+		}
+		writeJSON(w, http.StatusUnauthorized, errorResponse{"unauthorized"})
+	})
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/search", s.api(s.search))
+	mux.HandleFunc("/api/new", s.api(s.newDirs))
+	mux.HandleFunc("/api/sites", s.api(s.listSites))
+	mux.HandleFunc("/", s.ui)
+	return http.TimeoutHandler(s.authenticate(mux), s.timeout, `{"error":"request timed out"}`)
+}
+
+// intParam returns the non-negative integer parameter name, or def if it is not set.
+func intParam(params url.Values, name string, def int) (int, error) {
+	v := params.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, badRequest("invalid %s: %q", name, v)
+	}
+	return n, nil
+}
+
+// queryParams returns the site, order and pagination parameters of a request. Orders are given as repeated order
+// parameters or separated by commas, and defaultOrder is used if there are none.
+func queryParams(params url.Values, defaultOrder []string) (sql.Query, error) {
+	var orders []string
+	for _, v := range params["order"] {
+		orders = append(orders, strings.Split(v, ",")...)
+	}
+	if len(orders) == 0 {
+		orders = defaultOrder
+	}
+	order, err := sql.ParseOrders(orders)
+	if err != nil {
+		return sql.Query{}, &requestError{err}
+	}
+	limit, err := intParam(params, "limit", defaultAPILimit)
+	if err != nil {
+		return sql.Query{}, err
+	}
+	if limit == 0 || limit > maxAPILimit {
+		limit = maxAPILimit
+	}
+	offset, err := intParam(params, "offset", 0)
+	if err != nil {
+		return sql.Query{}, err
+	}
+	return sql.Query{Site: params.Get("site"), Order: order, Limit: limit, Offset: offset}, nil
+}
+
+// selectResults returns the results of q. files is whether to search for files.
+func (s *server) selectResults(ctx context.Context, q sql.Query, files bool) (resultsResponse, error) {
+	var (
+		rows *sql.Rows
+		err  error
+	)
+	if files {
+		rows, err = s.db.QueryFilesContext(ctx, q)
+	} else {
+		rows, err = s.db.QueryDirsContext(ctx, q)
+	}
+	if err != nil {
+		return resultsResponse{}, err
+	}
+	defer rows.Close()
+	results := []sql.Result{}
+	for rows.Next() {
+		d, err := scanDir(rows, files)
+		if err != nil {
+			return resultsResponse{}, err
+		}
+		results = append(results, sql.NewResult(d))
+	}
+	return resultsResponse{Results: results}, rows.Err()
+}
+
+func (s *server) search(r *http.Request) (interface{}, error) {
+	params := r.URL.Query()
+	q, err := queryParams(params, []string{"site", "path"})
+	if err != nil {
+		return nil, err
+	}
+	q.Keywords = params.Get("q")
+	if _, err := sql.ParseQuery(q.Keywords); err != nil {
+		return nil, err
+	}
+	files := false
+	switch typ := params.Get("type"); typ {
+	case "", "dir":
+	case "file":
+		files = true
+	default:
+		return nil, badRequest("invalid type: %q", typ)
+	}
+	return s.selectResults(r.Context(), q, files)
+}
+
+func (s *server) newDirs(r *http.Request) (interface{}, error) {
+	params := r.URL.Query()
+	q, err := queryParams(params, []string{"site", "first_seen:desc", "path"})
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	since := now.Add(-defaultNewSince)
+	if v := params.Get("since"); v != "" {
+		since, err = parseSince(v, now)
+		if err != nil {
+			return nil, &requestError{err}
+		}
+	}
+	q.Since = since.Unix()
+	return s.selectResults(r.Context(), q, false)
+}
+
+func newCrawlResponse(c *sql.Crawl) *crawlResponse {
+	if c == nil {
+		return nil
+	}
+	return &crawlResponse{
+		Started:   formatRFC3339(c.Started),
+		Finished:  formatRFC3339(c.Finished),
+		NumDirs:   c.NumDirs,
+		Delta:     c.Delta,
+		NumErrors: c.NumErrors,
+		Error:     c.Error,
+	}
+}
+
+func (s *server) listSites(r *http.Request) (interface{}, error) {
+	status, err := readSiteStatus(s.db, s.sites)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	sites := []siteResponse{}
+	for _, st := range status {
+		sites = append(sites, siteResponse{
+			Name:        st.name,
+			Status:      st.state(now, s.maxAge),
+			LastSuccess: newCrawlResponse(st.success),
+			LastFailure: newCrawlResponse(st.failure),
+		})
+	}
+	return sitesResponse{Sites: sites}, nil
+}
+
+func (c *Serve) Execute(args []string) error {
+	if len(args) != 0 {
+		return errUnexpectedArgs
+	}
+	cfg := mustReadConfig(c.Config)
+	db, err := sql.OpenReadOnly(cfg.Database)
+	if err != nil {
+		return err
+	}
+	listen := c.Listen
+	if listen == "" {
+		listen = cfg.Server.Listen
+	}
+	if listen == "" {
+		listen = defaultListen
+	}
+	timeout := cfg.Server.RequestTimeout()
+	s := &server{db: db, sites: cfg.Sites, config: cfg.Server, timeout: timeout, maxAge: c.MaxAge, logger: c.Logger, now: time.Now}
+	hs := &http.Server{
+		Addr:              listen,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: timeout,
+		ReadTimeout:       timeout,
+		// Leave time for the timeout response of the handler to be written
+		WriteTimeout: timeout + 5*time.Second,
+	}
+	c.Logger.Printf("Listening on %s", listen)
+	return hs.ListenAndServe()
+}
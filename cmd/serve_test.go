@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mpolden/fs/crawler"
+	"github.com/mpolden/fs/sql"
+)
+
+func testServer(t *testing.T, config crawler.Server) (*httptest.Server, *sql.Client) {
+	db, err := sql.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{
+		db:      db,
+		sites:   []crawler.Site{{Name: "site1"}, {Name: "site2"}},
+		config:  config,
+		timeout: 10 * time.Second,
+		maxAge:  24 * time.Hour,
+		logger:  log.New(ioutil.Discard, "", 0),
+		now:     func() time.Time { return time.Unix(1000, 0) },
+	}
+	return httptest.NewServer(s.handler()), db
+}
+
+func get(t *testing.T, url string, header http.Header, v interface{}) int {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res.StatusCode
+}
+
+func resultPaths(r resultsResponse) []string {
+	var paths []string
+	for _, res := range r.Results {
+		paths = append(paths, res.Site+" "+res.Path)
+	}
+	return strings.Fields(strings.Join(paths, " "))
+}
+
+func TestServeSearch(t *testing.T) {
+	ts, db := testServer(t, crawler.Server{})
+	defer ts.Close()
+	if err := db.Insert("site1", []sql.Dir{{Path: "/foo"}, {Path: "/bar"}, {Path: "/foo/bar"}}, []sql.File{{Dir: sql.Dir{Path: "/foo/baz.txt"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("site2", []sql.Dir{{Path: "/foo"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		query  string
+		status int
+		out    string
+	}{
+		{"?q=foo", 200, "site1 /foo site1 /foo/bar site2 /foo"},
+		{"?q=foo&site=site2", 200, "site2 /foo"},
+		{"?q=bar&order=path:desc", 200, "site1 /foo/bar site1 /bar"},
+		{"?q=foo&order=site:desc&order=path", 200, "site2 /foo site1 /foo site1 /foo/bar"},
+		{"?q=foo&order=site:desc,path:desc", 200, "site2 /foo site1 /foo/bar site1 /foo"},
+		{"?q=foo&limit=1&offset=1", 200, "site1 /foo/bar"},
+		{"?q=baz&type=file", 200, "site1 /foo/baz.txt"},
+		{"?q=nothing", 200, ""},
+		{"?q=(foo", 400, ""},
+		{"?q=foo&order=path%3B+DROP+TABLE+dir", 400, ""},
+		{"?q=foo&limit=x", 400, ""},
+		{"?q=foo&offset=-1", 400, ""},
+		{"?q=foo&type=link", 400, ""},
+	}
+	for _, tt := range tests {
+		var res resultsResponse
+		var errRes errorResponse
+		var v interface{} = &res
+		if tt.status != 200 {
+			v = &errRes
+		}
+		status := get(t, ts.URL+"/api/search"+tt.query, nil, v)
+		if status != tt.status {
+			t.Errorf("GET /api/search%s => %d, want %d", tt.query, status, tt.status)
+			continue
+		}
+		if tt.status != 200 {
+			if errRes.Error == "" {
+				t.Errorf("GET /api/search%s => no error message", tt.query)
+			}
+			continue
+		}
+		if got := strings.Join(resultPaths(res), " "); got != tt.out {
+			t.Errorf("GET /api/search%s => %q, want %q", tt.query, got, tt.out)
+		}
+		if res.Results == nil {
+			t.Errorf("GET /api/search%s => null results, want array", tt.query)
+		}
+	}
+	res, err := http.Post(ts.URL+"/api/search", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST /api/search => %d, want %d", res.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestServeNewAndSites(t *testing.T) {
+	ts, db := testServer(t, crawler.Server{})
+	defer ts.Close()
+	// The test server thinks it's 1000 seconds after the epoch
+	if err := db.Insert("site1", []sql.Dir{{Path: "/foo"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var res resultsResponse
+	if status := get(t, ts.URL+"/api/new?since=1970-01-01", nil, &res); status != 200 {
+		t.Fatalf("GET /api/new => %d", status)
+	}
+	if got := resultPaths(res); len(got) != 2 || got[1] != "/foo" {
+		t.Errorf("GET /api/new => %q, want /foo", got)
+	}
+	if status := get(t, ts.URL+"/api/new?since=x", nil, nil); status != 400 {
+		t.Errorf("GET /api/new?since=x => %d, want 400", status)
+	}
+	if err := db.InsertCrawl(sql.Crawl{Site: "site1", Started: 900, Finished: 960, Outcome: sql.OutcomeSuccess, NumDirs: 1, Delta: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var sites sitesResponse
+	if status := get(t, ts.URL+"/api/sites", nil, &sites); status != 200 {
+		t.Fatalf("GET /api/sites => %d", status)
+	}
+	want := []siteResponse{
+		{Name: "site1", Status: "ok", LastSuccess: &crawlResponse{Started: "1970-01-01T00:15:00Z", Finished: "1970-01-01T00:16:00Z", NumDirs: 1, Delta: 1}},
+		{Name: "site2", Status: "never"},
+	}
+	if len(sites.Sites) != len(want) {
+		t.Fatalf("GET /api/sites => %+v, want %+v", sites.Sites, want)
+	}
+	for i := range want {
+		got := sites.Sites[i]
+		if got.Name != want[i].Name || got.Status != want[i].Status || (got.LastSuccess == nil) != (want[i].LastSuccess == nil) ||
+			(got.LastSuccess != nil && *got.LastSuccess != *want[i].LastSuccess) || got.LastFailure != nil {
+			t.Errorf("GET /api/sites => %+v, want %+v", got, want[i])
+		}
+	}
+}
+
+func TestServeAuth(t *testing.T) {
+	var tests = []struct {
+		config crawler.Server
+		header http.Header
+		status int
+	}{
+		{crawler.Server{}, nil, 200},
+		{crawler.Server{Token: "secret"}, nil, 401},
+		{crawler.Server{Token: "secret"}, http.Header{"Authorization": {"Bearer secret"}}, 200},
+		{crawler.Server{Token: "secret"}, http.Header{"Authorization": {"Bearer wrong"}}, 401},
+		{crawler.Server{Username: "foo", Password: "bar"}, http.Header{"Authorization": {"Basic Zm9vOmJhcg=="}}, 200},
+		{crawler.Server{Username: "foo", Password: "bar"}, http.Header{"Authorization": {"Basic Zm9vOmJheg=="}}, 401},
+		{crawler.Server{Username: "foo", Password: "bar", Token: "secret"}, http.Header{"Authorization": {"Bearer secret"}}, 200},
+	}
+	for _, tt := range tests {
+		ts, _ := testServer(t, tt.config)
+		if status := get(t, ts.URL+"/api/sites", tt.header, nil); status != tt.status {
+			t.Errorf("GET /api/sites with %+v and %v => %d, want %d", tt.config, tt.header, status, tt.status)
+		}
+		ts.Close()
+	}
+}
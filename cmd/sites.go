@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mpolden/fs/crawler"
+	"github.com/mpolden/fs/sql"
+	"github.com/olekukonko/tablewriter"
+)
+
+type Sites struct {
+	opts
+	MaxAge time.Duration `short:"a" long:"max-age" description:"Consider sites stale when their last successful crawl is older than this" value-name:"DURATION" default:"24h"`
+}
+
+type siteStatus struct {
+	name    string
+	skip    bool
+	success *sql.Crawl
+	failure *sql.Crawl
+}
+
+func (s *siteStatus) state(now time.Time, maxAge time.Duration) string {
+	if s.skip {
+		return "skipped"
+	}
+	if s.success == nil {
+		return "never"
+	}
+	if now.Sub(time.Unix(s.success.Finished, 0)) > maxAge {
+		return "stale"
+	}
+	if s.failure != nil && s.failure.Started > s.success.Started {
+		return "failing"
+	}
+	return "ok"
+}
+
+// readSiteStatus returns the status of each of sites.
+func readSiteStatus(db *sql.Client, sites []crawler.Site) ([]siteStatus, error) {
+	var status []siteStatus
+	for _, site := range sites {
+		success, err := db.LastCrawl(site.Name, true)
+		if err != nil {
+			return nil, err
+		}
+		failure, err := db.LastCrawl(site.Name, false)
+		if err != nil {
+			return nil, err
+		}
+		status = append(status, siteStatus{name: site.Name, skip: site.Skip, success: success, failure: failure})
+	}
+	return status, nil
+}
+
+func formatTime(t int64) string {
+	return time.Unix(t, 0).UTC().Format("2006-01-02 15:04")
+}
+
+func writeSites(w io.Writer, sites []siteStatus, now time.Time, maxAge time.Duration) error {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Site", "Status", "Last success", "Duration", "Dirs", "Delta", "Errors", "Last failure", "Error"})
+	for _, s := range sites {
+		row := []string{s.name, s.state(now, maxAge), "", "", "", "", "", "", ""}
+		if c := s.success; c != nil {
+			row[2] = formatTime(c.Finished)
+			row[3] = (time.Duration(c.Finished-c.Started) * time.Second).String()
+			row[4] = strconv.Itoa(c.NumDirs)
+			row[5] = strconv.FormatInt(int64(c.Delta), 10)
+			if c.Delta > 0 {
+				row[5] = "+" + row[5]
+			}
+			row[6] = strconv.Itoa(c.NumErrors)
+		}
+		if c := s.failure; c != nil {
+			row[7] = formatTime(c.Finished)
+			row[8] = c.Error
+		}
+		table.Append(row)
+	}
+	table.Render()
+	return nil
+}
+
+func (c *Sites) Execute(args []string) error {
+	if len(args) != 0 {
+		return errUnexpectedArgs
+	}
+	cfg := mustReadConfig(c.Config)
+	db, err := openDatabase(cfg.Database)
+	if err != nil {
+		return err
+	}
+	sites, err := readSiteStatus(db, cfg.Sites)
+	if err != nil {
+		return err
+	}
+	return writeSites(os.Stdout, sites, time.Now(), c.MaxAge)
+}
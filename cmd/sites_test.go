@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mpolden/fs/sql"
+)
+
+func TestSitesExecute(t *testing.T) {
+	err := (&Sites{}).Execute([]string{"foo"})
+	if err != errUnexpectedArgs {
+		t.Errorf("Expected error: %s", errUnexpectedArgs)
+	}
+}
+
+func TestSiteState(t *testing.T) {
+	now := time.Date(2018, 9, 1, 12, 0, 0, 0, time.UTC)
+	hourAgo := &sql.Crawl{Started: now.Add(-time.Hour).Unix(), Finished: now.Add(-time.Hour).Unix()}
+	dayAgo := &sql.Crawl{Started: now.Add(-25 * time.Hour).Unix(), Finished: now.Add(-25 * time.Hour).Unix()}
+	var tests = []struct {
+		in  siteStatus
+		out string
+	}{
+		{siteStatus{skip: true, success: hourAgo}, "skipped"},
+		{siteStatus{}, "never"},
+		{siteStatus{failure: hourAgo}, "never"},
+		{siteStatus{success: dayAgo}, "stale"},
+		{siteStatus{success: hourAgo}, "ok"},
+		{siteStatus{success: hourAgo, failure: dayAgo}, "ok"},
+		{siteStatus{success: dayAgo, failure: hourAgo}, "stale"},
+		{siteStatus{success: &sql.Crawl{Started: hourAgo.Started - 1, Finished: hourAgo.Finished}, failure: hourAgo}, "failing"},
+	}
+	for i, tt := range tests {
+		if got := tt.in.state(now, 24*time.Hour); got != tt.out {
+			t.Errorf("#%d: state() => %q, want %q", i, got, tt.out)
+		}
+	}
+}
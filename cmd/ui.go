@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"context"
+	_ "embed" // For the template of the web UI
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mpolden/fs/sql"
+)
+
+// uiPageSize is the number of results on each page of the web UI.
+const uiPageSize = 50
+
+//go:embed ui.html
+var uiHTML string
+
+var uiTemplate = template.Must(template.New("ui").Funcs(template.FuncMap{
+	"date": formatDate,
+	"size": formatSize,
+}).Parse(uiHTML))
+
+// uiColumn is a column of the result table. Columns with a URL can be sorted by following it.
+type uiColumn struct {
+	Label      string
+	URL        string
+	Sorted     bool
+	Descending bool
+}
+
+type uiPage struct {
+	Query   string
+	Site    string
+	Sites   []string
+	After   string
+	Before  string
+	Order   string
+	Page    int
+	Columns []uiColumn
+	Results []sql.Dir
+	Shown   bool // Whether a search was made
+	PrevURL string
+	NextURL string
+	Error   string
+}
+
+// uiColumns are the columns of the result table, and the fields they are sorted by.
+var uiColumns = []struct{ label, field string }{
+	{"Site", "site"},
+	{"Path", "path"},
+	{"Date", "modified"},
+	{"Size", "size"},
+	{"Files", ""},
+	{"First seen", "first_seen"},
+}
+
+// parseUIDate parses a date entered in the web UI.
+func parseUIDate(name, value string) error {
+	if value == "" {
+		return nil
+	}
+	if _, err := time.Parse("2006-01-02", value); err != nil {
+		return errors.New("invalid " + name + " date: " + value)
+	}
+	return nil
+}
+
+// uiQuery returns the query and page requested by the web UI parameters of p.
+func uiQuery(p *uiPage) (sql.Query, error) {
+	keywords := p.Query
+	if err := parseUIDate("after", p.After); err != nil {
+		return sql.Query{}, err
+	}
+	if err := parseUIDate("before", p.Before); err != nil {
+		return sql.Query{}, err
+	}
+	// Dates are given to the query language, like fields typed into the search box. The query is checked on its own
+	// and parenthesized, so that the dates apply to all of it and not only to the last term of an OR
+	if keywords != "" && (p.After != "" || p.Before != "") {
+		if _, err := sql.ParseQuery(keywords); err != nil {
+			return sql.Query{}, err
+		}
+		keywords = "(" + keywords + ")"
+	}
+	if p.After != "" {
+		keywords += " after:" + p.After
+	}
+	if p.Before != "" {
+		keywords += " before:" + p.Before
+	}
+	orders := []string{"site", "path"}
+	if p.Order != "" {
+		orders = []string{p.Order, "path"}
+	}
+	order, err := sql.ParseOrders(orders)
+	if err != nil {
+		return sql.Query{}, err
+	}
+	// Fetch an extra result to tell if there is a next page
+	return sql.Query{
+		Keywords: keywords,
+		Site:     p.Site,
+		Order:    order,
+		Limit:    uiPageSize + 1,
+		Offset:   (p.Page - 1) * uiPageSize,
+	}, nil
+}
+
+// url returns the URL of p with the given parameters changed.
+func (p *uiPage) url(changes ...string) string {
+	params := url.Values{}
+	for k, v := range map[string]string{"q": p.Query, "site": p.Site, "after": p.After, "before": p.Before, "order": p.Order} {
+		if v != "" {
+			params.Set(k, v)
+		}
+	}
+	for i := 0; i+1 < len(changes); i += 2 {
+		params.Del(changes[i])
+		if changes[i+1] != "" {
+			params.Set(changes[i], changes[i+1])
+		}
+	}
+	return "?" + params.Encode()
+}
+
+func (p *uiPage) setColumns() {
+	sorted, err := sql.ParseOrder(p.Order)
+	if p.Order == "" || err != nil {
+		sorted = sql.Order{Field: "site"}
+	}
+	for _, c := range uiColumns {
+		col := uiColumn{Label: c.label}
+		if c.field != "" {
+			col.Sorted = sorted.Field == c.field
+			col.Descending = col.Sorted && sorted.Descending
+			order := c.field
+			if col.Sorted && !sorted.Descending {
+				order += ":desc"
+			}
+			col.URL = p.url("order", order, "page", "")
+		}
+		p.Columns = append(p.Columns, col)
+	}
+}
+
+// uiSearch sets the results of p, showing the given page. The search is interrupted when ctx is done.
+func (s *server) uiSearch(ctx context.Context, p *uiPage, page string) error {
+	if page != "" {
+		n, err := strconv.Atoi(page)
+		if err != nil || n < 1 {
+			return badRequest("invalid page: %s", page)
+		}
+		p.Page = n
+	}
+	// Listing the whole database is not useful, and slow when it is large
+	if p.Query == "" && p.Site == "" && p.After == "" && p.Before == "" {
+		return nil
+	}
+	q, err := uiQuery(p)
+	if err != nil {
+		return &requestError{err}
+	}
+	rows, err := s.db.QueryDirsContext(ctx, q)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var dirs []sql.Dir
+	for rows.Next() {
+		var d sql.Dir
+		if err := rows.Scan(&d); err != nil {
+			return err
+		}
+		dirs = append(dirs, d)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(dirs) > uiPageSize {
+		dirs = dirs[:uiPageSize]
+		p.NextURL = p.url("page", strconv.Itoa(p.Page+1))
+	}
+	if p.Page > 1 {
+		prev := ""
+		if p.Page > 2 {
+			prev = strconv.Itoa(p.Page - 1)
+		}
+		p.PrevURL = p.url("page", prev)
+	}
+	p.Results = dirs
+	p.Shown = true
+	return nil
+}
+
+// ui serves the web UI, which searches directories like fs search.
+func (s *server) ui(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	params := r.URL.Query()
+	p := &uiPage{
+		Query:  params.Get("q"),
+		Site:   params.Get("site"),
+		After:  params.Get("after"),
+		Before: params.Get("before"),
+		Order:  params.Get("order"),
+		Page:   1,
+	}
+	for _, site := range s.sites {
+		p.Sites = append(p.Sites, site.Name)
+	}
+	p.setColumns()
+	status := http.StatusOK
+	if err := s.uiSearch(r.Context(), p, params.Get("page")); err != nil {
+		p.Error = err.Error()
+		var reqErr *requestError
+		var syntaxErr *sql.SyntaxError
+		if errors.As(err, &reqErr) || errors.As(err, &syntaxErr) {
+			status = http.StatusBadRequest
+		} else {
+			status = http.StatusInternalServerError
+			s.logger.Printf("%s %s: %s", r.Method, r.URL, err)
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := uiTemplate.Execute(w, p); err != nil {
+		s.logger.Printf("%s %s: %s", r.Method, r.URL, err)
+	}
+}
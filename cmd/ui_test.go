@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mpolden/fs/crawler"
+	"github.com/mpolden/fs/sql"
+)
+
+func getUI(t *testing.T, url string) (int, string) {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(body)
+}
+
+func TestServeUI(t *testing.T) {
+	ts, db := testServer(t, crawler.Server{})
+	defer ts.Close()
+	var dirs []sql.Dir
+	for i := 0; i < uiPageSize+10; i++ {
+		dirs = append(dirs, sql.Dir{Path: fmt.Sprintf("/ubuntu/%03d", i), Size: int64(i), Modified: 1514764800})
+	}
+	dirs = append(dirs, sql.Dir{Path: "/debian/<b>", Modified: 1514764800})
+	if err := db.Insert("site1", dirs, nil); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		query    string
+		status   int
+		contains []string
+		excludes []string
+	}{
+		{"", 200, []string{`<option>site1</option>`, `<option>site2</option>`}, []string{"<table>", "No results"}},
+		{"?q=ubuntu", 200, []string{"/ubuntu/000", "/ubuntu/049", `href="?page=2&amp;q=ubuntu"`, "Page 1", "2018-01-01"},
+			[]string{"/ubuntu/050", "Previous"}},
+		{"?q=ubuntu&page=2", 200, []string{"/ubuntu/050", "/ubuntu/059", `href="?q=ubuntu">&larr; Previous`, "Page 2"},
+			[]string{"/ubuntu/049", "Next"}},
+		{"?q=ubuntu&order=size:desc", 200, []string{"/ubuntu/059", `href="?order=size&amp;q=ubuntu">Size</a> &#9660;`}, []string{"/ubuntu/009<"}},
+		{"?q=debian", 200, []string{"/debian/&lt;b&gt;"}, []string{"<b>"}},
+		{"?q=ubuntu&after=2018-01-02", 200, []string{"No results found."}, nil},
+		{"?q=ubuntu+OR+debian&after=2018-01-02", 200, []string{"No results found."}, nil},
+		{"?q=ubuntu)+OR+(debian&after=2018-01-02", 400, []string{`class="error"`, "at position 7: unexpected"}, nil},
+		{"?q=ubuntu&before=2018-01-02&site=site1", 200, []string{"/ubuntu/000", `<option selected>site1</option>`}, nil},
+		{"?q=(ubuntu", 400, []string{`class="error"`, "missing closing parenthesis"}, nil},
+		{"?q=ubuntu&after=yesterday", 400, []string{"invalid after date: yesterday"}, nil},
+		{"?q=ubuntu&order=owner", 400, []string{"invalid order field"}, nil},
+		{"?q=ubuntu&page=0", 400, []string{"invalid page: 0"}, nil},
+		{"/foo", 404, nil, nil},
+	}
+	for _, tt := range tests {
+		url := ts.URL + "/" + tt.query
+		if strings.HasPrefix(tt.query, "/") {
+			url = ts.URL + tt.query
+		}
+		status, body := getUI(t, url)
+		if status != tt.status {
+			t.Errorf("GET %s => %d, want %d", tt.query, status, tt.status)
+		}
+		for _, s := range tt.contains {
+			if !strings.Contains(body, s) {
+				t.Errorf("GET %s => body without %q", tt.query, s)
+			}
+		}
+		for _, s := range tt.excludes {
+			if strings.Contains(body, s) {
+				t.Errorf("GET %s => body with %q", tt.query, s)
+			}
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package cmd
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/mpolden/fs/crawler"
 	"github.com/mpolden/fs/sql"
@@ -9,9 +14,12 @@ import (
 
 type Update struct {
 	opts
-	Logger *log.Logger
-	Dryrun bool     `short:"n" long:"dry-run" description:"Only show what would be crawled"`
-	Sites  []string `short:"s" long:"site" description:"Update a single site" value-name:"NAME"`
+	Logger           *log.Logger
+	Dryrun           bool          `short:"n" long:"dry-run" description:"Only show what would be crawled"`
+	Force            bool          `long:"force" description:"Update database even if sites have shrunk by more than their MaxShrink"`
+	Sites            []string      `short:"s" long:"site" description:"Update a single site" value-name:"NAME"`
+	Wait             bool          `long:"wait" description:"Wait for another process updating the database to finish instead of failing"`
+	ProgressInterval time.Duration `short:"i" long:"progress-interval" description:"Interval between progress reports when not attached to a terminal" value-name:"DURATION" default:"30s"`
 }
 
 func (u *Update) updateSite(name string) bool {
@@ -23,15 +31,65 @@ func (u *Update) updateSite(name string) bool {
 	return len(u.Sites) == 0
 }
 
+// logSummary logs a summary of reports and returns the number of sites that had errors.
+func logSummary(logger *log.Logger, reports []crawler.Report) int {
+	failed := 0
+	for _, r := range reports {
+		if !r.Failed() {
+			logger.Printf("[%s] Updated %d directories", r.Site, r.Dirs)
+			continue
+		}
+		failed++
+		if r.Err != nil {
+			logger.Printf("[%s] Failed: %s", r.Site, r.Err)
+		}
+		if len(r.Errors) > 0 {
+			logger.Printf("[%s] %d directories failed to list:", r.Site, len(r.Errors))
+		}
+		for _, e := range r.Errors {
+			logger.Printf("[%s]   %s", r.Site, &e)
+		}
+	}
+	return failed
+}
+
 func (u *Update) Execute(args []string) error {
 	if len(args) != 0 {
 		return errUnexpectedArgs
 	}
+	if u.ProgressInterval <= 0 {
+		return fmt.Errorf("invalid --progress-interval %s: must be positive", u.ProgressInterval)
+	}
 	cfg := mustReadConfig(u.Config)
-	db, err := sql.New(cfg.Database)
+	// A dry run does not write to the database, so it neither locks nor migrates it
+	open := sql.Open
+	if !u.Dryrun {
+		lock, err := lockDatabase(cfg.Database, u.Wait, u.Logger)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+		open = sql.New
+	}
+	db, err := open(cfg.Database)
 	if err != nil {
 		return err
 	}
+	logger := u.Logger
+	progress := make(chan crawler.Progress, cfg.Concurrency)
+	done := make(chan bool)
+	if !u.Dryrun {
+		display := newProgressDisplay(os.Stderr, u.Logger, isTerminal(os.Stderr))
+		if display.live {
+			logger = log.New(display, u.Logger.Prefix(), u.Logger.Flags())
+		}
+		go func() {
+			display.run(progress, u.ProgressInterval)
+			close(done)
+		}()
+	}
+	var mu sync.Mutex
+	var reports []crawler.Report
 	sem := make(chan bool, cfg.Concurrency)
 	for _, site := range cfg.Sites {
 		if !u.updateSite(site.Name) || site.Skip {
@@ -40,25 +98,35 @@ func (u *Update) Execute(args []string) error {
 		sem <- true
 		go func(site crawler.Site) {
 			defer func() { <-sem }()
-			c := crawler.New(site, db, u.Logger)
+			c := crawler.New(site, db, logger)
 			if u.Dryrun {
 				c.Logf("Would update")
 				return
 			}
-			if err := c.Connect(); err != nil {
-				c.Logf("Failed to connect: %s", err)
-				return
-			}
-			defer c.Close()
-			if err := c.Run(); err != nil {
-				c.Logf("Failed crawling: %s", err)
-				return
-			}
+			c.Force = u.Force
+			c.SetProgress(progress)
+			c.Update()
+			mu.Lock()
+			defer mu.Unlock()
+			reports = append(reports, c.Report())
 		}(site)
 	}
 	// Wait for remaining goroutines to finish
 	for i := 0; i < cap(sem); i++ {
 		sem <- true
 	}
+	close(progress)
+	if u.Dryrun {
+		return nil
+	}
+	<-done
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Site < reports[j].Site })
+	failed := logSummary(u.Logger, reports)
+	if n := checkWatches(db, u.Logger); n > 0 {
+		return fmt.Errorf("%d watches could not be checked", n)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sites had errors", failed, len(reports))
+	}
 	return nil
 }
@@ -1,10 +1,137 @@
 package cmd
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mpolden/fs/crawler"
+	"github.com/mpolden/fs/sql"
+)
 
 func TestUpdateExecute(t *testing.T) {
 	err := (&Update{}).Execute([]string{"foo"})
 	if err != errUnexpectedArgs {
 		t.Errorf("Expected error: %s", errUnexpectedArgs)
 	}
+	for _, interval := range []time.Duration{0, -time.Second} {
+		want := "invalid --progress-interval " + interval.String() + ": must be positive"
+		if err := (&Update{ProgressInterval: interval}).Execute(nil); err == nil || err.Error() != want {
+			t.Errorf("got %v, want %q", err, want)
+		}
+	}
+}
+
+func TestLogSummary(t *testing.T) {
+	var buf bytes.Buffer
+	reports := []crawler.Report{
+		{Site: "foo", Dirs: 42},
+		{Site: "bar", Err: errors.New("connection refused")},
+		{Site: "baz", Dirs: 1, Errors: []crawler.ListError{{Path: "/dir", Code: 550, Message: "Permission denied"}}},
+	}
+	if got := logSummary(log.New(&buf, "", 0), reports); got != 2 {
+		t.Errorf("want 2 failed sites, got %d", got)
+	}
+	want := `[foo] Updated 42 directories
+[bar] Failed: connection refused
+[baz] 1 directories failed to list:
+[baz]   listing /dir failed: 550 Permission denied
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLockDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := filepath.Join(dir, "fs.db")
+	lock, err := lockDatabase(db, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "database is locked by process " + strconv.Itoa(os.Getpid()) + ": " + db + ".lock: another fs process may be updating the database, use --wait to wait for it"
+	if _, err := lockDatabase(db, false, nil); err == nil || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+	defer func(d time.Duration) { lockPollInterval = d }(lockPollInterval)
+	lockPollInterval = time.Millisecond
+	var buf bytes.Buffer
+	go func(lock *sql.Lock) {
+		time.Sleep(10 * time.Millisecond)
+		lock.Release()
+	}(lock)
+	waited, err := lockDatabase(db, true, log.New(&buf, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer waited.Release()
+	if want := "Waiting for process " + strconv.Itoa(os.Getpid()) + " to release " + db + ".lock\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestOpenDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := filepath.Join(dir, "fs.db")
+	want := db + ": database has pending migrations, run fs db migrate"
+	if _, err := openDatabase(db); err == nil || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+	c, err := sql.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, err := c.Version(); err != nil || version != 0 {
+		t.Errorf("got version %d, want unmigrated database", version)
+	}
+	if _, err := c.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openDatabase(db); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := filepath.Join(dir, "fs.db")
+	config := filepath.Join(dir, "fsrc")
+	if err := ioutil.WriteFile(config, []byte(`{"Database": "`+db+`", "Concurrency": 1, "Sites": [{"Name": "foo", "ConnectTimeout": "1s", "ReadTimeout": "1s"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Another process is updating the database
+	lock, err := sql.AcquireLock(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+	u := Update{opts: opts{Config: config}, Logger: log.New(ioutil.Discard, "", 0), Dryrun: true, ProgressInterval: time.Second}
+	if err := u.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	c, err := sql.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, err := c.Version(); err != nil || version != 0 {
+		t.Errorf("got version %d, want dry run to leave the database unmigrated", version)
+	}
 }
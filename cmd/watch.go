@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mpolden/fs/notify"
+	"github.com/mpolden/fs/sql"
+	"github.com/olekukonko/tablewriter"
+)
+
+type Watch struct{}
+
+type WatchAdd struct {
+	opts
+	Site   string `short:"s" long:"site" description:"Only match directories on a specific site" value-name:"NAME"`
+	Notify string `short:"N" long:"notify" description:"Where to send matches: stdout, command:COMMAND, file:PATH or webhook:URL" value-name:"NOTIFIER" default:"stdout"`
+}
+
+type WatchList struct {
+	opts
+}
+
+type WatchRemove struct {
+	opts
+}
+
+func (c *WatchAdd) Execute(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing query")
+	}
+	query := strings.Join(args, " ")
+	if _, err := sql.ParseQuery(query); err != nil {
+		return err
+	}
+	if _, err := notify.New(c.Notify); err != nil {
+		return err
+	}
+	cfg := mustReadConfig(c.Config)
+	db, err := openDatabase(cfg.Database)
+	if err != nil {
+		return err
+	}
+	id, err := db.InsertWatch(sql.Watch{Query: query, Site: c.Site, Notify: c.Notify})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added watch %d\n", id)
+	return nil
+}
+
+func writeWatches(w io.Writer, watches []sql.Watch) error {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"ID", "Query", "Site", "Notify"})
+	for _, w := range watches {
+		table.Append([]string{strconv.FormatInt(w.ID, 10), w.Query, w.Site, w.Notify})
+	}
+	table.Render()
+	return nil
+}
+
+func (c *WatchList) Execute(args []string) error {
+	if len(args) != 0 {
+		return errUnexpectedArgs
+	}
+	cfg := mustReadConfig(c.Config)
+	db, err := openDatabase(cfg.Database)
+	if err != nil {
+		return err
+	}
+	watches, err := db.SelectWatches()
+	if err != nil {
+		return err
+	}
+	return writeWatches(os.Stdout, watches)
+}
+
+func (c *WatchRemove) Execute(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing watch id")
+	}
+	var ids []int64
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	cfg := mustReadConfig(c.Config)
+	db, err := openDatabase(cfg.Database)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := db.DeleteWatch(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkWatches sends directories matching saved searches to their notifiers and returns the number of watches that
+// could not be checked.
+func checkWatches(db *sql.Client, logger *log.Logger) int {
+	watches, err := db.SelectWatches()
+	if err != nil {
+		logger.Printf("Failed to read watches: %s", err)
+		return 1
+	}
+	failed := 0
+	for _, w := range watches {
+		n, err := notify.New(w.Notify)
+		if err != nil {
+			logger.Printf("[watch %d] %s", w.ID, err)
+			failed++
+			continue
+		}
+		dirs, checked, err := db.MatchWatch(w)
+		if err != nil {
+			logger.Printf("[watch %d] %s", w.ID, err)
+			failed++
+			continue
+		}
+		if len(dirs) > 0 {
+			// Matches are reported again on the next check if notifying fails
+			if err := n.Notify(notify.NewMatch(w, dirs)); err != nil {
+				logger.Printf("[watch %d] Failed to notify: %s", w.ID, err)
+				failed++
+				continue
+			}
+		}
+		if err := db.AckWatch(w, checked); err != nil {
+			logger.Printf("[watch %d] %s", w.ID, err)
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mpolden/fs/sql"
+)
+
+func TestCheckWatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "matches.log")
+	for _, notify := range []string{"file:" + name, "invalid"} {
+		if _, err := db.InsertWatch(sql.Watch{Query: "foo", Notify: notify}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Insert("site1", []sql.Dir{{Path: "/foo"}, {Path: "/bar"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for i := 0; i < 2; i++ {
+		if got := checkWatches(db, log.New(&buf, "", 0)); got != 1 {
+			t.Errorf("checkWatches() => %d, want 1", got)
+		}
+	}
+	if want := "[watch 2] invalid notifier: \"invalid\"\n"; !strings.HasPrefix(buf.String(), want) {
+		t.Errorf("got log %q, want prefix %q", buf.String(), want)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"path":"/foo"`) || strings.Contains(lines[0], `"path":"/bar"`) {
+		t.Errorf("got matches %q, want a single match for /foo", lines)
+	}
+}
+
+func TestCheckWatchesNotifyFails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Notifying fails until the directory of the file exists
+	name := filepath.Join(dir, "missing", "matches.log")
+	if _, err := db.InsertWatch(sql.Watch{Query: "foo", Notify: "file:" + name}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("site1", []sql.Dir{{Path: "/foo"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New(ioutil.Discard, "", 0)
+	if got := checkWatches(db, logger); got != 1 {
+		t.Errorf("checkWatches() => %d, want 1", got)
+	}
+	if err := os.Mkdir(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if got := checkWatches(db, logger); got != 0 {
+			t.Errorf("checkWatches() => %d, want 0", got)
+		}
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"path":"/foo"`) {
+		t.Errorf("got matches %q, want a single match for /foo", lines)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -15,14 +16,37 @@ type Config struct {
 	Concurrency int
 	Sites       []Site
 	Default     Site
+	Server      Server
 }
 
+// defaultMaxShrink is the maximum decrease, in percent, in the number of directories of a site allowed by an update,
+// when MaxShrink is 0. A negative MaxShrink allows any decrease.
+const defaultMaxShrink = 50
+
+// defaultServerTimeout is the time the server spends on a request when no timeout is configured.
+const defaultServerTimeout = 30 * time.Second
+
+// Server configures the HTTP API served by fs serve. Requests are authenticated with basic auth if Username is set,
+// and with a bearer token if Token is set. Either is accepted when both are set.
+type Server struct {
+	Listen   string
+	Username string
+	Password string
+	Token    string
+	Timeout  string
+	timeout  time.Duration
+}
+
+// RequestTimeout returns the maximum time spent on a request.
+func (s *Server) RequestTimeout() time.Duration { return s.timeout }
+
 type Site struct {
 	Name           string
 	Address        string
 	Username       string
 	Password       string
 	Root           string
+	Roots          []Root
 	TLS            bool
 	ProxyURL       string
 	proxyURL       *url.URL
@@ -33,8 +57,65 @@ type Site struct {
 	readTimeout    time.Duration
 	Ignore         []string
 	IgnoreSymlinks bool
+	FollowSymlinks bool
+	SymlinkPath    string
+	IndexFiles     bool
+	MaxShrink      int
+	OnError        string
+	Retries        int
+	Schedule       string
+	schedule       Schedule
+	Jitter         string
+	jitter         time.Duration
+}
+
+// Root is a directory to crawl on a site. Depth and Ignore override the site defaults when set.
+type Root struct {
+	Path   string
+	Depth  int
+	Ignore []string
+}
+
+// roots returns the roots to crawl for site s. Root is used if no Roots are set.
+func (s *Site) roots() []Root {
+	if len(s.Roots) == 0 {
+		return []Root{{Path: s.Root, Ignore: s.Ignore}}
+	}
+	roots := make([]Root, len(s.Roots))
+	for i, r := range s.Roots {
+		if r.Ignore == nil {
+			r.Ignore = s.Ignore
+		}
+		roots[i] = r
+	}
+	return roots
+}
+
+// URL returns the URL of path p on site s. The scheme is ftps if the site uses TLS. The username of the site is
+// included unless it is anonymous, but never its password.
+func (s *Site) URL(p string) *url.URL {
+	u := &url.URL{Scheme: "ftp", Host: s.Address, Path: p}
+	if s.TLS {
+		u.Scheme = "ftps"
+	}
+	if s.Username != "" && !strings.EqualFold(s.Username, "anonymous") {
+		u.User = url.User(s.Username)
+	}
+	return u
 }
 
+// NextCrawl returns the time after t at which site s should be crawled by fs daemon, or the zero time if the site has
+// no schedule.
+func (s *Site) NextCrawl(t time.Time) time.Time {
+	if s.schedule == nil {
+		return time.Time{}
+	}
+	return s.schedule.Next(t)
+}
+
+// MaxJitter returns the maximum random delay added to scheduled crawls of site s.
+func (s *Site) MaxJitter() time.Duration { return s.jitter }
+
 func readConfig(r io.Reader) (Config, error) {
 	data, err := ioutil.ReadAll(r)
 	if err != nil {
@@ -49,6 +130,8 @@ func readConfig(r io.Reader) (Config, error) {
 		defaults.Sites[i] = defaults.Default
 		defaults.Sites[i].Ignore = make([]string, len(defaults.Default.Ignore))
 		copy(defaults.Sites[i].Ignore, defaults.Default.Ignore)
+		defaults.Sites[i].Roots = make([]Root, len(defaults.Default.Roots))
+		copy(defaults.Sites[i].Roots, defaults.Default.Roots)
 	}
 	// Unmarshal config again, letting individual sites override the defaults
 	cfg := defaults
@@ -68,6 +151,9 @@ func (c *Config) validate() error {
 	if len(c.Database) == 0 {
 		return fmt.Errorf("path to database must be set")
 	}
+	if err := c.Server.validate(); err != nil {
+		return err
+	}
 	for i, site := range c.Sites {
 		{
 			d, err := time.ParseDuration(site.ConnectTimeout)
@@ -90,6 +176,74 @@ func (c *Config) validate() error {
 			}
 			c.Sites[i].proxyURL = proxyURL
 		}
+		if site.MaxShrink > 100 {
+			return fmt.Errorf("%s: max shrink must be at most 100, or negative to allow any decrease", site.Name)
+		}
+		if site.MaxShrink == 0 {
+			c.Sites[i].MaxShrink = defaultMaxShrink
+		}
+		switch site.OnError {
+		case "":
+			c.Sites[i].OnError = OnErrorSkip
+		case OnErrorSkip, OnErrorAbort:
+		case OnErrorRetry:
+			if site.Retries == 0 {
+				c.Sites[i].Retries = defaultRetries
+			}
+		default:
+			return fmt.Errorf("%s: invalid error policy: %q", site.Name, site.OnError)
+		}
+		if site.Retries < 0 {
+			return fmt.Errorf("%s: retries must be >= 0", site.Name)
+		}
+		switch site.SymlinkPath {
+		case "", "link", "canonical":
+		default:
+			return fmt.Errorf("%s: invalid symlink path: %q", site.Name, site.SymlinkPath)
+		}
+		if site.Schedule != "" {
+			schedule, err := ParseSchedule(site.Schedule)
+			if err != nil {
+				return fmt.Errorf("%s: %s", site.Name, err)
+			}
+			c.Sites[i].schedule = schedule
+		}
+		if site.Jitter != "" {
+			d, err := time.ParseDuration(site.Jitter)
+			if err != nil {
+				return fmt.Errorf("%s: %s", site.Name, err)
+			}
+			if d < 0 {
+				return fmt.Errorf("%s: jitter must be >= 0", site.Name)
+			}
+			c.Sites[i].jitter = d
+		}
+		for _, r := range site.Roots {
+			if r.Path == "" {
+				return fmt.Errorf("%s: root path must be set", site.Name)
+			}
+			if r.Depth < 0 {
+				return fmt.Errorf("%s: depth of root %s must be >= 0", site.Name, r.Path)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) validate() error {
+	s.timeout = defaultServerTimeout
+	if s.Timeout != "" {
+		d, err := time.ParseDuration(s.Timeout)
+		if err != nil {
+			return fmt.Errorf("server: %s", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("server: timeout must be positive")
+		}
+		s.timeout = d
+	}
+	if (s.Username == "") != (s.Password == "") {
+		return fmt.Errorf("server: username and password must be set together")
 	}
 	return nil
 }
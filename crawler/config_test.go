@@ -66,3 +66,165 @@ func TestReadConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestReadConfigRoots(t *testing.T) {
+	jsonConfig := `
+{
+  "Database": "/tmp/foo.db",
+  "Concurrency": 1,
+  "Default": {
+    "ConnectTimeout": "1m",
+    "ReadTimeout": "30s",
+    "Root": "/",
+    "Ignore": ["foo"]
+  },
+  "Sites": [
+    {
+      "Name": "foo"
+    },
+    {
+      "Name": "bar",
+      "Roots": [
+        {"Path": "/movies"},
+        {"Path": "/tv", "Depth": 2, "Ignore": ["bar"]}
+      ]
+    }
+  ]
+}
+`
+	cfg, err := readConfig(strings.NewReader(jsonConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		i     int
+		roots []Root
+	}{
+		{0, []Root{{Path: "/", Ignore: []string{"foo"}}}},
+		{1, []Root{{Path: "/movies", Ignore: []string{"foo"}}, {Path: "/tv", Depth: 2, Ignore: []string{"bar"}}}},
+	}
+	for _, tt := range tests {
+		site := cfg.Sites[tt.i]
+		if got := site.roots(); !reflect.DeepEqual(got, tt.roots) {
+			t.Errorf("got roots=%+v, want roots=%+v for Name=%s", got, tt.roots, site.Name)
+		}
+	}
+}
+
+func TestReadConfigMaxShrink(t *testing.T) {
+	var tests = []struct {
+		site string
+		out  int
+		err  string
+	}{
+		{`"Name": "foo"`, 50, ""},
+		{`"Name": "foo", "MaxShrink": 20`, 20, ""},
+		{`"Name": "foo", "MaxShrink": 100`, 100, ""},
+		{`"Name": "foo", "MaxShrink": -1`, -1, ""},
+		{`"Name": "foo", "MaxShrink": 101`, 0, "foo: max shrink must be at most 100, or negative to allow any decrease"},
+	}
+	for _, tt := range tests {
+		site := `{"ConnectTimeout": "1s", "ReadTimeout": "1s", ` + tt.site + `}`
+		cfg, err := readConfig(strings.NewReader(`{"Database": "foo.db", "Concurrency": 1, "Sites": [` + site + `]}`))
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("readConfig(%s) = %v, want %q", tt.site, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cfg.Sites[0].MaxShrink; got != tt.out {
+			t.Errorf("readConfig(%s) => MaxShrink %d, want %d", tt.site, got, tt.out)
+		}
+	}
+}
+
+func TestSiteURL(t *testing.T) {
+	var tests = []struct {
+		site Site
+		path string
+		out  string
+	}{
+		{Site{Address: "ftp.example.com:21"}, "/pub/foo", "ftp://ftp.example.com:21/pub/foo"},
+		{Site{Address: "ftp.example.com", TLS: true}, "/pub/foo", "ftps://ftp.example.com/pub/foo"},
+		{Site{Address: "ftp.example.com", Username: "foo", Password: "secret"}, "/pub/Some Show #1", "ftp://foo@ftp.example.com/pub/Some%20Show%20%231"},
+		{Site{Address: "ftp.example.com", Username: "anonymous", Password: "foo@example.com"}, "/pub/foo", "ftp://ftp.example.com/pub/foo"},
+		{Site{Address: "ftp.example.com", Username: "Anonymous"}, "/pub/foo", "ftp://ftp.example.com/pub/foo"},
+	}
+	for _, tt := range tests {
+		if got := tt.site.URL(tt.path).String(); got != tt.out {
+			t.Errorf("URL(%q) => %q, want %q", tt.path, got, tt.out)
+		}
+	}
+}
+
+func TestReadConfigServer(t *testing.T) {
+	var tests = []struct {
+		server  string
+		timeout time.Duration
+		err     string
+	}{
+		{`{}`, 30 * time.Second, ""},
+		{`{"Listen": ":8080", "Token": "secret", "Timeout": "5s"}`, 5 * time.Second, ""},
+		{`{"Username": "foo", "Password": "bar"}`, 30 * time.Second, ""},
+		{`{"Username": "foo"}`, 0, "server: username and password must be set together"},
+		{`{"Timeout": "foo"}`, 0, `server: time: invalid duration "foo"`},
+		{`{"Timeout": "-1s"}`, 0, "server: timeout must be positive"},
+	}
+	for _, tt := range tests {
+		cfg, err := readConfig(strings.NewReader(`{"Database": "foo.db", "Concurrency": 1, "Server": ` + tt.server + `}`))
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("readConfig(%s) = %v, want %q", tt.server, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cfg.Server.RequestTimeout(); got != tt.timeout {
+			t.Errorf("readConfig(%s) => timeout %s, want %s", tt.server, got, tt.timeout)
+		}
+	}
+}
+
+func TestReadConfigSchedule(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		site   string
+		next   time.Time
+		jitter time.Duration
+		err    string
+	}{
+		{`{}`, time.Time{}, 0, ""},
+		{`{"Schedule": "6h", "Jitter": "10m"}`, base.Add(6 * time.Hour), 10 * time.Minute, ""},
+		{`{"Schedule": "30 2 * * *"}`, base.Add(150 * time.Minute), 0, ""},
+		{`{"Schedule": "daily"}`, time.Time{}, 0, `foo: invalid schedule: "daily": want a duration or a cron expression with 5 fields`},
+		{`{"Schedule": "6h", "Jitter": "-1m"}`, time.Time{}, 0, "foo: jitter must be >= 0"},
+	}
+	for _, tt := range tests {
+		site := `{"Name": "foo", "ConnectTimeout": "1s", "ReadTimeout": "1s"}`
+		if tt.site != `{}` {
+			site = `{"Name": "foo", "ConnectTimeout": "1s", "ReadTimeout": "1s", ` + tt.site[1:]
+		}
+		cfg, err := readConfig(strings.NewReader(`{"Database": "foo.db", "Concurrency": 1, "Sites": [` + site + `]}`))
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("readConfig(%s) = %v, want %q", tt.site, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := cfg.Sites[0]
+		if got := s.NextCrawl(base); !got.Equal(tt.next) {
+			t.Errorf("readConfig(%s) => next crawl %s, want %s", tt.site, got, tt.next)
+		}
+		if got := s.MaxJitter(); got != tt.jitter {
+			t.Errorf("readConfig(%s) => jitter %s, want %s", tt.site, got, tt.jitter)
+		}
+	}
+}
@@ -1,12 +1,15 @@
 package crawler
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/mpolden/fs/ftp"
 	"github.com/mpolden/fs/sql"
@@ -17,22 +20,60 @@ type dirLister interface {
 	filterFiles([]ftp.File) []ftp.File
 }
 
+type dirStat struct {
+	size     int64
+	numFiles int
+}
+
+// progressInterval is the minimum interval between progress reports.
+const progressInterval = 100 * time.Millisecond
+
+// ShrinkError is returned when the number of directories found in a crawl is smaller than the number of stored
+// directories by more than the site allows.
+type ShrinkError struct {
+	Count     int
+	Stored    int
+	MaxShrink int
+}
+
+func (e *ShrinkError) Error() string {
+	return fmt.Sprintf("refusing to replace %d directories with %d, which is a decrease of more than %d%%",
+		e.Stored, e.Count, e.MaxShrink)
+}
+
 type Crawler struct {
+	// Force disables the check on number of directories before inserting the crawled directories
+	Force     bool
 	site      Site
+	root      Root
+	stats     map[string]dirStat
 	logger    *log.Logger
 	ftpClient *ftp.Client
+	dial      func() (*ftp.Client, error)
 	dbClient  *sql.Client
+	ctx       context.Context
+	progress  chan<- Progress
+	status    Progress
+	crawl     sql.Crawl
+	errors    []ListError
+	err       error
+	started   time.Time
+	reported  time.Time
 }
 
 func New(site Site, dbClient *sql.Client, logger *log.Logger) *Crawler {
-	return &Crawler{
+	c := &Crawler{
 		dbClient: dbClient,
 		site:     site,
 		logger:   logger,
+		ctx:      context.Background(),
 	}
+	c.dial = c.dialSite
+	return c
 }
 
-func (c *Crawler) Connect() error {
+// dialSite connects and logs in to the site.
+func (c *Crawler) dialSite() (*ftp.Client, error) {
 	var ftpClient *ftp.Client
 	var err error
 	if c.site.proxyURL != nil {
@@ -41,7 +82,7 @@ func (c *Crawler) Connect() error {
 		ftpClient, err = ftp.DialTimeout("tcp", c.site.Address, c.site.connectTimeout)
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 	ftpClient.ReadTimeout = c.site.readTimeout
 	if c.site.TLS {
@@ -49,6 +90,15 @@ func (c *Crawler) Connect() error {
 	} else {
 		err = ftpClient.Login(c.site.Username, c.site.Password)
 	}
+	if err != nil {
+		ftpClient.Close()
+		return nil, err
+	}
+	return ftpClient, nil
+}
+
+func (c *Crawler) Connect() error {
+	ftpClient, err := c.dial()
 	if err != nil {
 		return err
 	}
@@ -57,53 +107,257 @@ func (c *Crawler) Connect() error {
 	return nil
 }
 
+// reconnect replaces a connection that is likely broken with a new one. The old connection is kept if connecting
+// fails.
+func (c *Crawler) reconnect() error {
+	ftpClient, err := c.dial()
+	if err != nil {
+		return err
+	}
+	c.ftpClient.Close()
+	c.ftpClient = ftpClient
+	c.Logf("Reconnected to %s", c.site.Address)
+	return nil
+}
+
 func (c *Crawler) Close() error {
 	return c.ftpClient.Quit()
 }
 
+// SetProgress sets the channel to send progress reports to. Reports are dropped if the channel is not ready to
+// receive, except for the final report.
+func (c *Crawler) SetProgress(ch chan<- Progress) {
+	c.progress = ch
+}
+
+func (c *Crawler) setPhase(phase string) {
+	c.status.Phase = phase
+	c.report(false)
+}
+
+func (c *Crawler) report(force bool) {
+	if c.progress == nil {
+		return
+	}
+	now := time.Now()
+	if !force && now.Sub(c.reported) < progressInterval {
+		return
+	}
+	c.reported = now
+	p := c.status
+	p.Elapsed = now.Sub(c.started)
+	if force {
+		c.progress <- p
+		return
+	}
+	select {
+	case c.progress <- p:
+	default:
+	}
+}
+
 func (c *Crawler) Logf(format string, v ...interface{}) {
 	prefix := fmt.Sprintf("[%s] ", c.site.Name)
 	c.logger.Printf(prefix+format, v...)
 }
 
-func (c *Crawler) list(path string) ([]ftp.File, error) {
+func (c *Crawler) listDir(path string) (string, error) {
 	// STAT may not support listing directories containing spaces, change to directory before listing
 	p := path
 	if strings.Contains(p, " ") {
 		if err := c.ftpClient.Cwd(p); err != nil {
-			c.Logf("Changing directory to %s failed: %s", p, err)
-			return nil, nil
+			return "", err
 		}
 		p = "." // Current directory
 	}
-	message, err := c.ftpClient.Stat(p)
-	if err != nil {
-		c.Logf("Listing directory %s failed: %s", p, err)
-		return nil, nil
+	return c.ftpClient.Stat(p)
+}
+
+func (c *Crawler) list(path string) ([]ftp.File, error) {
+	retries := 0
+	if c.site.OnError == OnErrorRetry {
+		retries = c.site.Retries
+	}
+	var lerr *ListError
+	for i := 0; i <= retries; i++ {
+		if err := c.ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Only a reply from the server leaves the connection usable. After any other error, retry on a new one
+		if lerr != nil && lerr.Code == 0 {
+			if err := c.reconnect(); err != nil {
+				c.Logf("Reconnecting failed: %s", err)
+				continue
+			}
+		}
+		message, err := c.listDir(path)
+		if err != nil {
+			lerr = newListError(path, err)
+			c.Logf("Listing directory %s failed: %s", path, err)
+			if lerr.permanent() {
+				break
+			}
+			continue
+		}
+		c.status.Listed++
+		c.report(false)
+		files, err := ftp.ParseFiles(path, strings.NewReader(message))
+		if err != nil {
+			return nil, err
+		}
+		if c.stats != nil {
+			c.stats[path] = statDir(files)
+		}
+		return files, nil
+	}
+	c.status.Errors++
+	c.errors = append(c.errors, *lerr)
+	if c.site.OnError == OnErrorAbort {
+		return nil, lerr
 	}
-	return ftp.ParseFiles(path, strings.NewReader(message))
+	return nil, nil
 }
 
 func (c *Crawler) filterFiles(files []ftp.File) []ftp.File {
-	return filterFiles(files, c.site.Ignore, c.site.IgnoreSymlinks)
+	return filterFiles(files, c.root.Ignore, c.site.IgnoreSymlinks && !c.site.FollowSymlinks)
 }
 
-func (c *Crawler) walk(path string) ([]ftp.File, error) {
-	return walk(c, path, -1)
+// walk walks root. It also returns the entries of the directories listed during the walk, keyed by their path.
+func (c *Crawler) walk(root Root) ([]ftp.File, map[string][]ftp.File, error) {
+	c.root = root
+	w := newWalker(c)
+	w.progress = &c.status
+	w.stats = c.stats
+	w.followSymlinks = c.site.FollowSymlinks
+	w.canonicalPaths = c.site.SymlinkPath == "canonical"
+	maxdepth := -1
+	if root.Depth > 0 {
+		maxdepth = maxDepth(root.Path, root.Depth)
+	} else {
+		w.detectDepth = true
+	}
+	files, err := w.walk(root.Path, root.Path, maxdepth, nil)
+	return files, w.listed, err
+}
+
+// Update connects to the site, crawls it and records the crawl in the database.
+func (c *Crawler) Update() error {
+	return c.UpdateContext(context.Background())
 }
 
+// UpdateContext is like Update. The crawl fails before listing the next directory when ctx is done.
+func (c *Crawler) UpdateContext(ctx context.Context) error {
+	c.ctx = ctx
+	c.start()
+	err := c.update()
+	c.finish(err)
+	return err
+}
+
+func (c *Crawler) update() error {
+	if err := c.Connect(); err != nil {
+		c.Logf("Failed to connect: %s", err)
+		return err
+	}
+	defer c.Close()
+	if err := c.run(); err != nil {
+		c.Logf("Failed crawling: %s", err)
+		return err
+	}
+	return nil
+}
+
+// Run crawls a connected site, inserts the result into the database and records the crawl.
 func (c *Crawler) Run() error {
-	c.Logf("Walking %s", c.site.Root)
-	files, err := c.walk(c.site.Root)
+	c.start()
+	err := c.run()
+	c.finish(err)
+	return err
+}
+
+// Report returns a report of the last crawl.
+func (c *Crawler) Report() Report {
+	return Report{Site: c.site.Name, Dirs: c.crawl.NumDirs, Err: c.err, Errors: c.errors}
+}
+
+func (c *Crawler) start() {
+	c.errors = nil
+	c.err = nil
+	c.started = time.Now()
+	c.status = Progress{Site: c.site.Name}
+	c.crawl = sql.Crawl{Site: c.site.Name, Started: c.started.Unix()}
+}
+
+func (c *Crawler) finish(err error) {
+	c.err = err
+	c.crawl.Finished = time.Now().Unix()
+	c.crawl.NumErrors = len(c.errors)
 	if err != nil {
+		c.status.Phase = PhaseFailed
+		c.crawl.Outcome = sql.OutcomeFailure
+		if _, ok := err.(*ShrinkError); ok {
+			c.crawl.Outcome = sql.OutcomeRejected
+		}
+		c.crawl.Error = err.Error()
+	} else {
+		c.status.Phase = PhaseDone
+		c.crawl.Outcome = sql.OutcomeSuccess
+	}
+	c.status.Done = true
+	c.report(true)
+	if err := c.dbClient.InsertCrawl(c.crawl); err != nil {
+		c.Logf("Failed to record crawl: %s", err)
+	}
+}
+
+func (c *Crawler) run() error {
+	c.stats = make(map[string]dirStat)
+	c.setPhase(PhaseWalking)
+	var files, regularFiles []ftp.File
+	for _, root := range c.site.roots() {
+		c.Logf("Walking %s", root.Path)
+		fs, listed, err := c.walk(root)
+		if err != nil {
+			return err
+		}
+		files = append(files, fs...)
+		if c.site.IndexFiles {
+			c.Logf("Walking files in %s", root.Path)
+			rs, err := walkFiles(c, fs, listed)
+			if err != nil {
+				return err
+			}
+			regularFiles = append(regularFiles, rs...)
+		}
+	}
+	c.setPhase(PhaseListing)
+	if err := statDirs(c, files, c.stats); err != nil {
 		return err
 	}
-	dirs := toDirs(files)
-	c.Logf("Inserting %d directories into database", len(dirs))
-	if err := c.dbClient.Insert(c.site.Name, dirs); err != nil {
+	dirs := toDirs(files, c.stats)
+	sqlFiles := toFiles(regularFiles)
+	c.status.Indexed = len(dirs) + len(sqlFiles)
+	c.setPhase(PhaseInserting)
+	count, err := c.dbClient.CountDirs(c.site.Name)
+	if err != nil {
 		return err
 	}
-	return nil
+	c.crawl.NumDirs = len(dirs)
+	c.crawl.Delta = len(dirs) - count
+	if !c.Force && shrinks(count, len(dirs), c.site.MaxShrink) {
+		return &ShrinkError{Count: len(dirs), Stored: count, MaxShrink: c.site.MaxShrink}
+	}
+	c.Logf("Inserting %d directories and %d files into database", len(dirs), len(sqlFiles))
+	return c.dbClient.Insert(c.site.Name, dirs, sqlFiles)
+}
+
+// shrinks returns whether count is smaller than stored by more than maxShrink percent. A negative maxShrink allows
+// any decrease.
+func shrinks(stored, count, maxShrink int) bool {
+	if maxShrink < 0 || count >= stored {
+		return false
+	}
+	return (stored-count)*100 > stored*maxShrink
 }
 
 func filterFiles(files []ftp.File, excludedFiles []string, ignoreSymlinks bool) []ftp.File {
@@ -123,18 +377,78 @@ Loop:
 	return keep
 }
 
-func toDirs(files []ftp.File) []sql.Dir {
+func statDir(files []ftp.File) dirStat {
+	var s dirStat
+	for _, f := range files {
+		if f.Mode.IsRegular() {
+			s.size += int64(f.Size)
+			s.numFiles++
+		}
+	}
+	return s
+}
+
+// statDirs lists directories in files which have not yet been listed, and records their size and number of files
+// in stats.
+func statDirs(lister dirLister, files []ftp.File, stats map[string]dirStat) error {
+	for _, f := range files {
+		if !f.Mode.IsDir() {
+			continue
+		}
+		if _, ok := stats[f.Path]; ok {
+			continue
+		}
+		children, err := lister.list(f.Path)
+		if err != nil {
+			return err
+		}
+		stats[f.Path] = statDir(children)
+	}
+	return nil
+}
+
+func toDirs(files []ftp.File, stats map[string]dirStat) []sql.Dir {
 	keep := []sql.Dir{}
+	seen := make(map[string]bool)
 	for _, f := range files {
+		// Roots may overlap
+		if seen[f.Path] {
+			continue
+		}
+		seen[f.Path] = true
 		d := sql.Dir{
 			Path:     f.Path,
 			Modified: f.Modified.Unix(),
+			Size:     stats[f.Path].size,
+			NumFiles: stats[f.Path].numFiles,
 		}
 		keep = append(keep, d)
 	}
 	return keep
 }
 
+func toFiles(files []ftp.File) []sql.File {
+	keep := []sql.File{}
+	seen := make(map[string]bool)
+	for _, f := range files {
+		if seen[f.Path] {
+			continue
+		}
+		seen[f.Path] = true
+		sf := sql.File{
+			Dir: sql.Dir{
+				Path:     f.Path,
+				Modified: f.Modified.Unix(),
+				Size:     int64(f.Size),
+			},
+			Owner: f.User,
+			Mode:  uint32(f.Mode),
+		}
+		keep = append(keep, sf)
+	}
+	return keep
+}
+
 func sortFiles(files []ftp.File) {
 	sort.Slice(files, func(i, j int) bool {
 		// Sort file names starting with underscore first
@@ -170,30 +484,255 @@ func containsOnlyDir(files []ftp.File) bool {
 	return true
 }
 
-func walk(lister dirLister, path string, maxdepth int) ([]ftp.File, error) {
-	files, err := list(lister, path)
+// maxDepth returns the absolute max depth to walk when indexing depth levels below root.
+func maxDepth(root string, depth int) int {
+	base := 0
+	if root = filepath.Clean(root); root != "/" {
+		base = strings.Count(root, "/")
+	}
+	return base + depth - 1
+}
+
+// maxSymlinks is the maximum number of chained symlinks to follow when resolving a symlink.
+const maxSymlinks = 8
+
+// walker walks a directory tree.
+type walker struct {
+	lister         dirLister
+	detectDepth    bool
+	followSymlinks bool
+	canonicalPaths bool
+	listings       map[string][]ftp.File
+	listed         map[string][]ftp.File
+	visited        map[string]bool
+	stats          map[string]dirStat
+	progress       *Progress
+}
+
+func newWalker(lister dirLister) *walker {
+	return &walker{
+		lister:   lister,
+		listings: make(map[string][]ftp.File),
+		listed:   make(map[string][]ftp.File),
+		visited:  make(map[string]bool),
+	}
+}
+
+// stat returns the file at path by listing its parent directory.
+func (w *walker) stat(path string) (ftp.File, bool, error) {
+	dir := filepath.Dir(path)
+	files, ok := w.listings[dir]
+	if !ok {
+		var err error
+		files, err = w.lister.list(dir)
+		if err != nil {
+			return ftp.File{}, false, err
+		}
+		w.listings[dir] = files
+	}
+	name := filepath.Base(path)
+	for _, f := range files {
+		if f.Name == name {
+			f.Path = path
+			return f, true, nil
+		}
+	}
+	return ftp.File{}, false, nil
+}
+
+// resolve returns the file that the symlink at linkPath points to, following chained symlinks. The returned bool is
+// false if the symlink is broken.
+func (w *walker) resolve(linkPath, target string) (ftp.File, bool, error) {
+	for i := 0; i < maxSymlinks; i++ {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(linkPath), target)
+		}
+		target = filepath.Clean(target)
+		if target == "/" {
+			return ftp.File{Path: target, Mode: os.ModeDir}, true, nil
+		}
+		f, ok, err := w.stat(target)
+		if err != nil || !ok {
+			return ftp.File{}, false, err
+		}
+		if !f.IsSymlink() {
+			return f, true, nil
+		}
+		linkPath, target = f.Path, f.Target
+	}
+	return ftp.File{}, false, nil
+}
+
+// list lists the directory at realPath. Paths of the returned files are relative to path, or realPath if canonical
+// paths are used. Symlinks are resolved if they are followed, and keep their symlink bit. The returned files are
+// recorded in listed by that path, and a directory is only listed once. If stats is set, the size and number of files
+// of the directory are recorded in stats by that path.
+func (w *walker) list(path, realPath string) ([]ftp.File, error) {
+	if w.canonicalPaths {
+		path = realPath
+	}
+	if files, ok := w.listed[path]; ok {
+		return files, nil
+	}
+	files, err := w.lister.list(realPath)
 	if err != nil {
 		return nil, err
 	}
-	for _, f := range files {
-		if !f.Mode.IsDir() {
+	if w.stats != nil {
+		w.stats[path] = statDir(files)
+	}
+	files = w.lister.filterFiles(files)
+	sortFiles(files)
+	for i := range files {
+		f := &files[i]
+		f.Path = filepath.Join(path, f.Name)
+		if !w.followSymlinks || !f.IsSymlink() {
 			continue
 		}
+		target, ok, err := w.resolve(filepath.Join(realPath, f.Name), f.Target)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue // Broken symlink
+		}
+		f.Mode = target.Mode | os.ModeSymlink
+		f.Target = target.Path
+		if w.canonicalPaths {
+			f.Path = target.Path
+		}
+	}
+	w.listed[path] = files
+	return files, nil
+}
+
+// isCycle returns whether walking target would lead back to any of the directories in chain.
+func isCycle(target string, chain []string) bool {
+	for _, p := range chain {
+		if target == "/" || p == target || strings.HasPrefix(p, target+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// walk walks the directory at realPath, where path is the path used for determining depth. Chain holds the real
+// paths of the parent directories.
+func (w *walker) walk(path, realPath string, maxdepth int, chain []string) ([]ftp.File, error) {
+	w.visited[realPath] = true
+	chain = append(chain, realPath)
+	listed, err := w.list(path, realPath)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]ftp.File, 0, len(listed))
+	var walked []ftp.File
+	w.found(listed)
+	for _, f := range listed {
+		if f.Mode.IsDir() {
+			w.queue(-1)
+		}
 		subpath := filepath.Join(path, f.Name)
+		subRealPath := filepath.Join(realPath, f.Name)
+		if f.Mode.IsDir() && f.IsSymlink() {
+			subRealPath = f.Target
+			if isCycle(subRealPath, chain) {
+				// The canonical path of this symlink is a parent directory
+				if !w.canonicalPaths {
+					files = append(files, f)
+				}
+				continue
+			}
+		}
+		files = append(files, f)
+		if !f.Mode.IsDir() {
+			continue
+		}
+		// Directory has already been indexed with its canonical path
+		if w.canonicalPaths && w.visited[subRealPath] {
+			continue
+		}
 		depth := strings.Count(subpath, "/")
-		if maxdepth > 0 && depth > maxdepth {
+		// A max depth of 0 is a limit, as set by a root "/" with depth 1. Only -1 means no limit
+		if maxdepth >= 0 && depth > maxdepth {
 			continue
 		}
-		// Peek at sub-directory to determine max depth
-		children, err := list(lister, subpath)
+		if w.detectDepth {
+			// Peek at sub-directory to determine max depth
+			children, err := w.list(subpath, subRealPath)
+			if err != nil {
+				return nil, err
+			}
+			if !containsOnlyDir(children) {
+				maxdepth = depth - 1
+				if maxdepth == 0 {
+					// Top-level directories containing files do not limit the depth of their siblings
+					maxdepth = -1
+				}
+				continue
+			}
+		}
+		fs, err := w.walk(subpath, subRealPath, maxdepth, chain)
 		if err != nil {
 			return nil, err
 		}
-		if !containsOnlyDir(children) {
-			maxdepth = depth - 1
-			continue
+		walked = append(walked, fs...)
+	}
+	return append(files, walked...), nil
+}
+
+// found records the entries of a walked directory in progress.
+func (w *walker) found(files []ftp.File) {
+	if w.progress != nil {
+		w.progress.Indexed += len(files)
+		w.progress.Queued += containedDirs(files)
+	}
+}
+
+// queue records that n directories have been added to, or removed from, the queue of directories to walk.
+func (w *walker) queue(n int) {
+	if w.progress != nil {
+		w.progress.Queued += n
+	}
+}
+
+func containedDirs(files []ftp.File) int {
+	n := 0
+	for _, f := range files {
+		if f.Mode.IsDir() {
+			n++
+		}
+	}
+	return n
+}
+
+// leafDirs returns the directories in files that have no children in files, i.e. the directories that were not
+// walked into.
+func leafDirs(files []ftp.File) []ftp.File {
+	parents := make(map[string]bool)
+	for _, f := range files {
+		parents[filepath.Dir(f.Path)] = true
+	}
+	var leaves []ftp.File
+	for _, f := range files {
+		if f.Mode.IsDir() && !parents[f.Path] {
+			leaves = append(leaves, f)
+		}
+	}
+	return leaves
+}
+
+// walkFiles returns the regular files in walked, and all regular files below its leaf directories. Directories in
+// listed are not listed again.
+func walkFiles(lister dirLister, walked []ftp.File, listed map[string][]ftp.File) ([]ftp.File, error) {
+	var files []ftp.File
+	for _, f := range walked {
+		if f.Mode.IsRegular() {
+			files = append(files, f)
 		}
-		fs, err := walk(lister, subpath, maxdepth)
+	}
+	for _, d := range leafDirs(walked) {
+		fs, err := walkTree(lister, d.Path, listed)
 		if err != nil {
 			return nil, err
 		}
@@ -201,3 +740,33 @@ func walk(lister dirLister, path string, maxdepth int) ([]ftp.File, error) {
 	}
 	return files, nil
 }
+
+func walkTree(lister dirLister, path string, listed map[string][]ftp.File) ([]ftp.File, error) {
+	files, ok := listed[path]
+	if !ok {
+		var err error
+		if files, err = list(lister, path); err != nil {
+			return nil, err
+		}
+		for i := range files {
+			files[i].Path = filepath.Join(path, files[i].Name)
+		}
+	}
+	var regularFiles []ftp.File
+	for _, f := range files {
+		// Directories reached through symlinks are not walked for files
+		if f.IsSymlink() {
+			continue
+		}
+		if f.Mode.IsDir() {
+			fs, err := walkTree(lister, f.Path, listed)
+			if err != nil {
+				return nil, err
+			}
+			regularFiles = append(regularFiles, fs...)
+		} else if f.Mode.IsRegular() {
+			regularFiles = append(regularFiles, f)
+		}
+	}
+	return regularFiles, nil
+}
@@ -1,15 +1,42 @@
 package crawler
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
 	"os"
 	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/mpolden/fs/ftp"
 	"github.com/mpolden/fs/sql"
 )
 
+// fakeServer replies to each STAT command with the next reply in replies
+func fakeServer(t *testing.T, replies []string) *ftp.Client {
+	server, client := net.Pipe()
+	go func() {
+		defer server.Close()
+		fmt.Fprint(server, "220 Ready\r\n")
+		r := bufio.NewReader(server)
+		for _, reply := range replies {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			fmt.Fprint(server, reply)
+		}
+	}()
+	c, err := ftp.NewClient(client, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
 type fakeLister struct{}
 
 func (l *fakeLister) filterFiles(files []ftp.File) []ftp.File {
@@ -78,6 +105,122 @@ func (l *fakeLister) list(path string) ([]ftp.File, error) {
 	return nil, fmt.Errorf("unknown path: %s", path)
 }
 
+func testWalk(lister dirLister, path string, maxdepth int, detectDepth bool) ([]ftp.File, error) {
+	w := newWalker(lister)
+	w.detectDepth = detectDepth
+	return w.walk(path, path, maxdepth, nil)
+}
+
+type symlinkLister struct{}
+
+func (l *symlinkLister) filterFiles(files []ftp.File) []ftp.File { return files }
+
+func (l *symlinkLister) list(path string) ([]ftp.File, error) {
+	switch path {
+	case "/":
+		return []ftp.File{
+			{Name: "a", Mode: os.ModeDir},
+			{Name: "b", Mode: os.ModeDir},
+		}, nil
+	case "/a":
+		return []ftp.File{
+			{Name: "x", Mode: os.ModeDir},
+			{Name: "loop", Mode: os.ModeSymlink, Target: "/a"},
+			{Name: "up", Mode: os.ModeSymlink, Target: ".."},
+			{Name: "tob", Mode: os.ModeSymlink, Target: "../b"},
+			{Name: "broken", Mode: os.ModeSymlink, Target: "/nope"},
+		}, nil
+	case "/a/x":
+		return []ftp.File{{Name: "file"}}, nil
+	case "/b":
+		return []ftp.File{
+			{Name: "y", Mode: os.ModeDir},
+			{Name: "toa", Mode: os.ModeSymlink, Target: "/a/x"},
+		}, nil
+	case "/b/y":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown path: %s", path)
+}
+
+func TestWalkSymlinks(t *testing.T) {
+	var tests = []struct {
+		canonical bool
+		want      []string
+	}{
+		{false, []string{"/a", "/a/broken", "/a/loop", "/a/tob", "/a/tob/toa", "/a/tob/toa/file", "/a/tob/y", "/a/up",
+			"/a/x", "/a/x/file", "/b", "/b/toa", "/b/toa/file", "/b/y"}},
+		{true, []string{"/a", "/a/broken", "/a/x", "/a/x/file", "/b", "/b/y"}},
+	}
+	for _, tt := range tests {
+		w := newWalker(&symlinkLister{})
+		w.followSymlinks = true
+		w.canonicalPaths = tt.canonical
+		files, err := w.walk("/", "/", -1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, d := range toDirs(files, nil) {
+			got = append(got, d.Path)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("walk with canonical=%t => %s, want %s", tt.canonical, got, tt.want)
+		}
+	}
+}
+
+func TestWalkStatsSymlinks(t *testing.T) {
+	w := newWalker(&symlinkLister{})
+	w.followSymlinks = true
+	w.stats = make(map[string]dirStat)
+	files, err := w.walk("/", "/", -1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lister := &statLister{}
+	if err := statDirs(lister, files, w.stats); err != nil {
+		t.Fatal(err)
+	}
+	// Only symlinks to parent directories are not walked
+	if want := []string{"/a/loop", "/a/up"}; !reflect.DeepEqual(lister.listed, want) {
+		t.Errorf("want listed=%s, got %s", want, lister.listed)
+	}
+}
+
+func TestIsCycle(t *testing.T) {
+	var tests = []struct {
+		target string
+		chain  []string
+		out    bool
+	}{
+		{"/", []string{"/"}, true},
+		{"/a", []string{"/", "/a"}, true},
+		{"/a", []string{"/", "/b", "/a/x"}, true},
+		{"/a/x", []string{"/", "/a"}, false},
+		{"/ab", []string{"/", "/a"}, false},
+	}
+	for _, tt := range tests {
+		if got := isCycle(tt.target, tt.chain); got != tt.out {
+			t.Errorf("isCycle(%q, %q) => %t, want %t", tt.target, tt.chain, got, tt.out)
+		}
+	}
+}
+
+type statLister struct{ listed []string }
+
+func (l *statLister) filterFiles(files []ftp.File) []ftp.File { return files }
+
+func (l *statLister) list(path string) ([]ftp.File, error) {
+	l.listed = append(l.listed, path)
+	return []ftp.File{
+		{Name: "file1", Size: 10},
+		{Name: "file2", Size: 20},
+		{Name: "subdir", Size: 4096, Mode: os.ModeDir},
+	}, nil
+}
+
 func TestWalk(t *testing.T) {
 	want := []ftp.File{
 		{Name: "dir1", Mode: os.ModeDir},
@@ -93,7 +236,7 @@ func TestWalk(t *testing.T) {
 		{Name: "Dir2-1", Mode: os.ModeDir},
 		{Name: "dir2-2-1", Mode: os.ModeDir},
 	}
-	got, err := walk(&fakeLister{}, "/", -1)
+	got, err := testWalk(&fakeLister{}, "/", -1, true)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -111,6 +254,131 @@ func TestWalk(t *testing.T) {
 	}
 }
 
+func TestWalkProgress(t *testing.T) {
+	var p Progress
+	w := newWalker(&fakeLister{})
+	w.detectDepth = true
+	w.progress = &p
+	files, err := w.walk("/", "/", -1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Queued != 0 {
+		t.Errorf("want Queued=0, got %d", p.Queued)
+	}
+	if p.Indexed != len(files) {
+		t.Errorf("want Indexed=%d, got %d", len(files), p.Indexed)
+	}
+}
+
+func TestWalkMaxDepth(t *testing.T) {
+	var tests = []struct {
+		root  string
+		depth int
+		want  []string
+	}{
+		{"/", 1, []string{"dir1", "dir2"}},
+		{"/dir1", 1, []string{"dir1-1", "dir1-2"}},
+		{"/dir1", 2, []string{"dir1-1", "dir1-2", "dir1-1-1", "dir1-1-2", "dir1-2-1", "dir1-2-2"}},
+	}
+	for _, tt := range tests {
+		files, err := testWalk(&fakeLister{}, tt.root, maxDepth(tt.root, tt.depth), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range files {
+			got = append(got, f.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("walk(%q) with depth %d => %s, want %s", tt.root, tt.depth, got, tt.want)
+		}
+	}
+}
+
+type shallowLister struct{}
+
+func (l *shallowLister) filterFiles(files []ftp.File) []ftp.File { return files }
+
+func (l *shallowLister) list(path string) ([]ftp.File, error) {
+	switch path {
+	case "/":
+		return []ftp.File{{Name: "a", Mode: os.ModeDir}, {Name: "b", Mode: os.ModeDir}}, nil
+	case "/a", "/b/b1":
+		return []ftp.File{{Name: "file"}}, nil
+	case "/b":
+		return []ftp.File{{Name: "b1", Mode: os.ModeDir}}, nil
+	}
+	return nil, fmt.Errorf("unknown path: %s", path)
+}
+
+func TestWalkDetectDepthTopLevel(t *testing.T) {
+	files, err := testWalk(&shallowLister{}, "/", -1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range files {
+		got = append(got, f.Name)
+	}
+	// Files in /a do not stop the walk from descending into /b
+	if want := []string{"a", "b", "b1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestWalkFiles(t *testing.T) {
+	walked := []ftp.File{
+		{Path: "/README", Name: "README"},
+		{Path: "/dir2/Dir2-1", Name: "Dir2-1", Mode: os.ModeDir},
+		{Path: "/dir2/_dir2-2", Name: "_dir2-2", Mode: os.ModeDir},
+		{Path: "/dir2/_dir2-2/dir2-2-1", Name: "dir2-2-1", Mode: os.ModeDir},
+	}
+	files, err := walkFiles(&fakeLister{}, walked, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range files {
+		got = append(got, f.Path)
+	}
+	want := []string{"/README", "/dir2/Dir2-1/file2-1-1", "/dir2/_dir2-2/dir2-2-1/file2-2-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+type countingLister struct {
+	fakeLister
+	listed map[string]int
+}
+
+func (l *countingLister) list(path string) ([]ftp.File, error) {
+	l.listed[path]++
+	return l.fakeLister.list(path)
+}
+
+func TestWalkFilesReusesListings(t *testing.T) {
+	lister := &countingLister{listed: make(map[string]int)}
+	w := newWalker(lister)
+	w.detectDepth = true
+	walked, err := w.walk("/dir2", "/dir2", -1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := walkFiles(lister, walked, w.listed); err != nil {
+		t.Fatal(err)
+	}
+	for path, n := range lister.listed {
+		if n > 1 {
+			t.Errorf("%s listed %d times, want 1", path, n)
+		}
+	}
+	if _, ok := lister.listed["/dir2/Dir2-1"]; !ok {
+		t.Errorf("want /dir2/Dir2-1 listed, got %v", lister.listed)
+	}
+}
+
 func TestSortFiles(t *testing.T) {
 	got := []ftp.File{
 		{Name: "_C"},
@@ -142,14 +410,185 @@ func TestSortFiles(t *testing.T) {
 	}
 }
 
+func TestStatDirs(t *testing.T) {
+	lister := &statLister{}
+	files := []ftp.File{
+		{Path: "/dir1", Mode: os.ModeDir},
+		{Path: "/dir2", Mode: os.ModeDir},
+		{Path: "/file"},
+	}
+	stats := map[string]dirStat{"/dir1": {size: 1}}
+	if err := statDirs(lister, files, stats); err != nil {
+		t.Fatal(err)
+	}
+	dirs := toDirs(files, stats)
+	want := []sql.Dir{
+		{Path: "/dir1", Modified: dirs[0].Modified, Size: 1},
+		{Path: "/dir2", Modified: dirs[1].Modified, Size: 30, NumFiles: 2},
+		{Path: "/file", Modified: dirs[2].Modified},
+	}
+	if !reflect.DeepEqual(dirs, want) {
+		t.Errorf("want %+v, got %+v", want, dirs)
+	}
+	if want := []string{"/dir2"}; !reflect.DeepEqual(lister.listed, want) {
+		t.Errorf("want listed=%s, got %s", want, lister.listed)
+	}
+}
+
+func TestToFiles(t *testing.T) {
+	files := []ftp.File{{Path: "/foo/bar.txt", Name: "bar.txt", User: "foo", Size: 42, Mode: 0644}}
+	want := sql.File{Dir: sql.Dir{Path: "/foo/bar.txt", Modified: files[0].Modified.Unix(), Size: 42}, Owner: "foo", Mode: 0644}
+	got := toFiles(append(files, files...))
+	if len(got) != 1 {
+		t.Fatalf("want 1 file, got %d", len(got))
+	}
+	if !reflect.DeepEqual(got[0], want) {
+		t.Errorf("want %+v, got %+v", want, got[0])
+	}
+}
+
 func TestToDirs(t *testing.T) {
 	files := []ftp.File{{Path: "/foo", Name: "foo"}}
 	want := sql.Dir{Path: "/foo"}
-	got := toDirs(files)
+	got := toDirs(files, nil)
 	if len(got) == 0 {
 		t.Fatal("expected non-zero length")
 	}
 	if got[0].Path != want.Path {
 		t.Errorf("want Path=%s, got Path=%s", want.Path, got[0].Path)
 	}
+	if got := toDirs(append(files, files...), nil); len(got) != 1 {
+		t.Errorf("want 1 dir, got %d", len(got))
+	}
+}
+
+func TestShrinks(t *testing.T) {
+	var tests = []struct {
+		stored, count, maxShrink int
+		out                      bool
+	}{
+		{100, 10, -1, false},
+		{100, 99, 0, true},
+		{100, 50, 50, false},
+		{100, 49, 50, true},
+		{100, 150, 10, false},
+		{0, 0, 10, false},
+		{10, 0, 100, false},
+		{10, 0, 99, true},
+	}
+	for _, tt := range tests {
+		if got := shrinks(tt.stored, tt.count, tt.maxShrink); got != tt.out {
+			t.Errorf("shrinks(%d, %d, %d) => %t, want %t", tt.stored, tt.count, tt.maxShrink, got, tt.out)
+		}
+	}
+}
+
+func TestListErrorPolicy(t *testing.T) {
+	listing := "213-Status follows:\r\ndrwxr-xr-x 2 foo bar 4096 Jul 25 2014 dir\r\n213 End of status\r\n"
+	var tests = []struct {
+		policy  string
+		replies []string
+		files   int
+		errors  int
+		err     bool
+	}{
+		{OnErrorSkip, []string{listing}, 1, 0, false},
+		{OnErrorSkip, []string{"450 Busy\r\n", listing}, 0, 1, false},
+		{OnErrorRetry, []string{"450 Busy\r\n", listing}, 1, 0, false},
+		{OnErrorRetry, []string{"550 No such directory\r\n", listing}, 0, 1, false}, // Permanent error is not retried
+		{OnErrorAbort, []string{"450 Busy\r\n", listing}, 0, 1, true},
+	}
+	for i, tt := range tests {
+		c := New(Site{Name: "foo", OnError: tt.policy, Retries: 1}, nil, log.New(ioutil.Discard, "", 0))
+		c.ftpClient = fakeServer(t, tt.replies)
+		files, err := c.list("/")
+		if (err != nil) != tt.err {
+			t.Errorf("#%d: list() => error %v, want error=%t", i, err, tt.err)
+		}
+		if len(files) != tt.files {
+			t.Errorf("#%d: want %d files, got %d", i, tt.files, len(files))
+		}
+		if r := c.Report(); len(r.Errors) != tt.errors {
+			t.Errorf("#%d: want %d errors, got %d", i, tt.errors, len(r.Errors))
+		}
+	}
+}
+
+func TestListReconnect(t *testing.T) {
+	listing := "213-Status follows:\r\ndrwxr-xr-x 2 foo bar 4096 Jul 25 2014 dir\r\n213 End of status\r\n"
+	var tests = []struct {
+		replies []string
+		files   int
+		dials   int
+	}{
+		{[]string{"450 Busy\r\n", listing}, 1, 0}, // Server reply is retried on the same connection
+		{nil, 1, 1}, // Connection dropped before replying
+	}
+	for i, tt := range tests {
+		c := New(Site{Name: "foo", OnError: OnErrorRetry, Retries: 1}, nil, log.New(ioutil.Discard, "", 0))
+		c.ftpClient = fakeServer(t, tt.replies)
+		dials := 0
+		c.dial = func() (*ftp.Client, error) {
+			dials++
+			return fakeServer(t, []string{listing}), nil
+		}
+		files, err := c.list("/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != tt.files {
+			t.Errorf("#%d: want %d files, got %d", i, tt.files, len(files))
+		}
+		if dials != tt.dials {
+			t.Errorf("#%d: want %d reconnects, got %d", i, tt.dials, dials)
+		}
+	}
+}
+
+func TestListReconnectFails(t *testing.T) {
+	c := New(Site{Name: "foo", OnError: OnErrorRetry, Retries: 2}, nil, log.New(ioutil.Discard, "", 0))
+	c.ftpClient = fakeServer(t, nil)
+	dials := 0
+	c.dial = func() (*ftp.Client, error) {
+		dials++
+		return nil, fmt.Errorf("connection refused")
+	}
+	files, err := c.list("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("want 0 files, got %d", len(files))
+	}
+	if dials != 2 {
+		t.Errorf("want 2 reconnects, got %d", dials)
+	}
+	if r := c.Report(); len(r.Errors) != 1 {
+		t.Errorf("want 1 error, got %d", len(r.Errors))
+	}
+}
+
+func TestUpdateContextCancelled(t *testing.T) {
+	db, err := sql.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing := "213-Status follows:\r\ndrwxr-xr-x 2 foo bar 4096 Jul 25 2014 dir\r\n213 End of status\r\n"
+	c := New(Site{Name: "foo", OnError: OnErrorSkip}, db, log.New(ioutil.Discard, "", 0))
+	c.dial = func() (*ftp.Client, error) { return fakeServer(t, []string{listing}), nil }
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.UpdateContext(ctx); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	crawl, err := db.LastCrawl("foo", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crawl == nil || crawl.Outcome != sql.OutcomeFailure {
+		t.Errorf("got %+v, want failed crawl", crawl)
+	}
+	if n, err := db.CountDirs("foo"); err != nil || n != 0 {
+		t.Errorf("got %d dirs, want 0", n)
+	}
 }
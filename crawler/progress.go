@@ -0,0 +1,40 @@
+package crawler
+
+import (
+	"fmt"
+	"time"
+)
+
+// Phases of a crawl.
+const (
+	PhaseWalking   = "walking"
+	PhaseListing   = "listing"
+	PhaseInserting = "inserting"
+	PhaseDone      = "done"
+	PhaseFailed    = "failed"
+)
+
+// Progress describes the progress of crawling a site.
+type Progress struct {
+	Site    string
+	Phase   string
+	Listed  int
+	Queued  int
+	Indexed int
+	Errors  int
+	Elapsed time.Duration
+	Done    bool
+}
+
+// Rate returns the number of directories listed per second.
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Listed) / p.Elapsed.Seconds()
+}
+
+func (p Progress) String() string {
+	return fmt.Sprintf("[%s] %s: %d listed, %d queued, %d indexed, %d errors, %s elapsed, %.1f dirs/s",
+		p.Site, p.Phase, p.Listed, p.Queued, p.Indexed, p.Errors, p.Elapsed.Round(time.Second), p.Rate())
+}
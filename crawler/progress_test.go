@@ -0,0 +1,26 @@
+package crawler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	var tests = []struct {
+		in   Progress
+		rate float64
+		out  string
+	}{
+		{Progress{Site: "foo", Phase: PhaseWalking}, 0, "[foo] walking: 0 listed, 0 queued, 0 indexed, 0 errors, 0s elapsed, 0.0 dirs/s"},
+		{Progress{Site: "foo", Phase: PhaseDone, Listed: 150, Queued: 2, Indexed: 300, Errors: 1, Elapsed: time.Minute},
+			2.5, "[foo] done: 150 listed, 2 queued, 300 indexed, 1 errors, 1m0s elapsed, 2.5 dirs/s"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.Rate(); got != tt.rate {
+			t.Errorf("Rate() => %f, want %f", got, tt.rate)
+		}
+		if got := tt.in.String(); got != tt.out {
+			t.Errorf("String() => %q, want %q", got, tt.out)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package crawler
+
+import (
+	"fmt"
+	"net/textproto"
+)
+
+// Policies for handling directories that fail to list.
+const (
+	OnErrorSkip  = "skip"
+	OnErrorRetry = "retry"
+	OnErrorAbort = "abort"
+)
+
+// defaultRetries is the number of retries used by the retry policy, unless configured.
+const defaultRetries = 3
+
+// ListError is an error listing a directory. Code is the FTP reply code, or 0 if the error was not a reply from the
+// server.
+type ListError struct {
+	Path    string
+	Code    int
+	Message string
+}
+
+func newListError(path string, err error) *ListError {
+	if e, ok := err.(*textproto.Error); ok {
+		return &ListError{Path: path, Code: e.Code, Message: e.Msg}
+	}
+	return &ListError{Path: path, Message: err.Error()}
+}
+
+func (e *ListError) Error() string {
+	if e.Code == 0 {
+		return fmt.Sprintf("listing %s failed: %s", e.Path, e.Message)
+	}
+	return fmt.Sprintf("listing %s failed: %d %s", e.Path, e.Code, e.Message)
+}
+
+// permanent returns whether listing may succeed if retried. Replies in the 5xx range are permanent failures.
+func (e *ListError) permanent() bool {
+	return e.Code >= 500 && e.Code < 600
+}
+
+// Report summarizes a crawl of a site.
+type Report struct {
+	Site   string
+	Dirs   int
+	Err    error
+	Errors []ListError
+}
+
+// Failed returns whether the crawl failed, or any directories failed to list.
+func (r *Report) Failed() bool {
+	return r.Err != nil || len(r.Errors) > 0
+}
@@ -0,0 +1,28 @@
+package crawler
+
+import (
+	"errors"
+	"net/textproto"
+	"testing"
+)
+
+func TestListError(t *testing.T) {
+	var tests = []struct {
+		in        error
+		out       string
+		permanent bool
+	}{
+		{&textproto.Error{Code: 550, Msg: "No such file or directory"}, "listing /foo failed: 550 No such file or directory", true},
+		{&textproto.Error{Code: 421, Msg: "Timeout"}, "listing /foo failed: 421 Timeout", false},
+		{errors.New("i/o timeout"), "listing /foo failed: i/o timeout", false},
+	}
+	for _, tt := range tests {
+		err := newListError("/foo", tt.in)
+		if got := err.Error(); got != tt.out {
+			t.Errorf("Error() => %q, want %q", got, tt.out)
+		}
+		if got := err.permanent(); got != tt.permanent {
+			t.Errorf("permanent() => %t, want %t for %q", got, tt.permanent, tt.out)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package crawler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule determines when a site is crawled by fs daemon.
+type Schedule interface {
+	// Next returns the first time after t at which a crawl should start, or the zero time if there is none.
+	Next(t time.Time) time.Time
+}
+
+// interval schedules crawls a fixed duration apart.
+type interval time.Duration
+
+func (i interval) Next(t time.Time) time.Time { return t.Add(time.Duration(i)) }
+
+// cronSchedule schedules crawls at the times matching a cron expression. Each field is a bit set of the values it
+// matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// Like cron, a day matches if either day of month or day of week matches, unless one of them is *
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// ParseSchedule parses s as either a duration, such as 6h, or a cron expression with the five fields minute, hour,
+// day of month, month and day of week. Fields are * or a comma-separated list of values, ranges (1-5) and steps (*/15
+// or 0-30/10). The macros @hourly, @daily, @weekly, @monthly and @yearly are also accepted.
+func ParseSchedule(s string) (Schedule, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < time.Minute {
+			return nil, fmt.Errorf("schedule interval must be at least 1m: %q", s)
+		}
+		return interval(d), nil
+	}
+	expr := s
+	if macro, ok := cronMacros[s]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid schedule: %q: want a duration or a cron expression with %d fields", s, len(cronFields))
+	}
+	var c cronSchedule
+	sets := []*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, f := range fields {
+		set, err := parseCronField(f, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule: %q: %s", s, err)
+		}
+		*sets[i] = set
+	}
+	// Both 0 and 7 are Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar = fields[2] == "*"
+	c.dowStar = fields[4] == "*"
+	if c.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, fmt.Errorf("invalid schedule: %q: never matches", s)
+	}
+	return &c, nil
+}
+
+func parseCronField(s string, f cronField) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(s, ",") {
+		expr, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %s: %q", f.name, part)
+			}
+			expr, step = part[:i], n
+		}
+		lo, hi := f.min, f.max
+		if expr != "*" {
+			bounds := strings.SplitN(expr, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid %s: %q", f.name, part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid %s: %q", f.name, part)
+				}
+			} else if step > 1 {
+				// A single value with a step, like 5/15, means every step starting at the value
+				hi = f.max
+			}
+		}
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("%s out of range %d-%d: %q", f.name, f.min, f.max, part)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func has(set uint64, v int) bool { return set&(1<<uint(v)) != 0 }
+
+func (c *cronSchedule) matchDay(t time.Time) bool {
+	dom, dow := has(c.dom, t.Day()), has(c.dow, int(t.Weekday()))
+	switch {
+	case c.domStar && c.dowStar:
+		return true
+	case c.domStar:
+		return dow
+	case c.dowStar:
+		return dom
+	}
+	return dom || dow
+}
+
+// Next returns the first minute after t matching the expression, in the location of t.
+func (c *cronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every valid expression matches within a leap year cycle
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !has(c.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !has(c.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !has(c.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,63 @@
+package crawler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2024, 1, 31, 10, 30, 15, 0, time.UTC) // A Wednesday
+	var tests = []struct {
+		in   string
+		want time.Time
+	}{
+		{"6h", base.Add(6 * time.Hour)},
+		{"* * * * *", time.Date(2024, 1, 31, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"30 3 * * *", time.Date(2024, 2, 1, 3, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2024, 1, 31, 13, 0, 0, 0, time.UTC)},
+		{"0 8,20 * * *", time.Date(2024, 1, 31, 20, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		// Day of month or day of week when both are set
+		{"0 0 15 * 5", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.in)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %s", tt.in, err)
+			continue
+		}
+		if got := s.Next(base); !got.Equal(tt.want) {
+			t.Errorf("ParseSchedule(%q).Next(%s) = %s, want %s", tt.in, base, got, tt.want)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"30s",
+		"daily",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"0 0 30 2 *",
+	} {
+		if _, err := ParseSchedule(in); err == nil {
+			t.Errorf("ParseSchedule(%q): want error", in)
+		}
+	}
+}
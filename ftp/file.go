@@ -23,6 +23,7 @@ type File struct {
 	Size       int
 	Modified   time.Time
 	Mode       os.FileMode
+	Target     string
 }
 
 func parseMode(s string) (os.FileMode, error) {
@@ -118,8 +119,17 @@ func ParseFile(s string) (File, error) {
 	if err != nil {
 		return File{}, err
 	}
+	name := parts[8]
+	target := ""
+	// Symlinks are listed as "name -> target"
+	if fileMode&os.ModeSymlink != 0 {
+		if i := strings.Index(name, " -> "); i >= 0 {
+			name, target = name[:i], name[i+4:]
+		}
+	}
 	return File{
-		Name:       parts[8],
+		Name:       name,
+		Target:     target,
 		User:       user,
 		Group:      group,
 		Size:       size,
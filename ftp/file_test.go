@@ -87,6 +87,27 @@ func TestParseFile(t *testing.T) {
 				Mode:       os.FileMode(os.ModeDir + 0777),
 			},
 		},
+		{"lrwxrwxrwx   1 foo   bar         12 Jul 25   2014 link with spaces -> ../target dir",
+			File{
+				Name:   "link with spaces",
+				Target: "../target dir",
+				User:   "foo", Group: "bar",
+				NumEntries: 1,
+				Size:       12,
+				Modified:   date(2014, 7, 25),
+				Mode:       os.FileMode(os.ModeSymlink + 0777),
+			},
+		},
+		{"-rw-r--r--   1 foo   bar         42 Jul 25   2014 not a -> link",
+			File{
+				Name: "not a -> link",
+				User: "foo", Group: "bar",
+				NumEntries: 1,
+				Size:       42,
+				Modified:   date(2014, 7, 25),
+				Mode:       os.FileMode(0644),
+			},
+		},
 	}
 	for _, tt := range tests {
 		rv, err := ParseFile(tt.in)
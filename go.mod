@@ -9,5 +9,6 @@ require (
 	github.com/mattn/go-sqlite3 v1.9.0
 	github.com/olekukonko/tablewriter v0.0.0-20180506121414-d4647c9c7a84
 	golang.org/x/net v0.0.0-20180906233101-161cd47e91fd
+	golang.org/x/text v0.3.0
 	google.golang.org/appengine v1.1.0 // indirect
 )
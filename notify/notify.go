@@ -0,0 +1,148 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/mpolden/fs/sql"
+)
+
+// Match holds the new directories matching a saved search. Directories have the same fields as in the output of fs
+// search --format json.
+type Match struct {
+	Watch int64        `json:"watch"`
+	Query string       `json:"query"`
+	Site  string       `json:"site,omitempty"`
+	Dirs  []sql.Result `json:"dirs"`
+}
+
+// NewMatch creates a match of dirs for watch w.
+func NewMatch(w sql.Watch, dirs []sql.Dir) Match {
+	m := Match{Watch: w.ID, Query: w.Query, Site: w.Site, Dirs: make([]sql.Result, len(dirs))}
+	for i, d := range dirs {
+		m.Dirs[i] = sql.NewResult(d)
+	}
+	return m
+}
+
+// Notifier sends matches somewhere.
+type Notifier interface {
+	Notify(m Match) error
+}
+
+// Writer writes matches in a human-readable form to an io.Writer.
+type Writer struct{ w io.Writer }
+
+// Command runs a shell command with matches encoded as JSON on its standard input.
+type Command struct{ command string }
+
+// File appends matches as JSON lines to a file.
+type File struct{ name string }
+
+// Webhook posts matches encoded as JSON to an URL.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// New creates a notifier from spec, which is one of: stdout, command:COMMAND, file:PATH or webhook:URL.
+func New(spec string) (Notifier, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	kind := parts[0]
+	arg := ""
+	if len(parts) == 2 {
+		arg = parts[1]
+	}
+	switch kind {
+	case "stdout":
+		if arg != "" {
+			break
+		}
+		return NewWriter(os.Stdout), nil
+	case "command":
+		if arg == "" {
+			break
+		}
+		return &Command{command: arg}, nil
+	case "file":
+		if arg == "" {
+			break
+		}
+		return &File{name: arg}, nil
+	case "webhook":
+		u, err := url.Parse(arg)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			break
+		}
+		return &Webhook{url: arg, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("invalid notifier: %q", spec)
+}
+
+// NewWriter creates a notifier writing to w.
+func NewWriter(w io.Writer) *Writer { return &Writer{w: w} }
+
+func (n *Writer) Notify(m Match) error {
+	if _, err := fmt.Fprintf(n.w, "Watch %d (%q) matched %d new directories:\n", m.Watch, m.Query, len(m.Dirs)); err != nil {
+		return err
+	}
+	for _, d := range m.Dirs {
+		if _, err := fmt.Fprintf(n.w, "  %s %s\n", d.Site, d.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Command) Notify(m Match) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("sh", "-c", n.command)
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s: %s", n.command, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (n *File) Notify(m Match) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(n.name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (n *Webhook) Notify(m Match) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	res, err := n.client.Post(n.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s: unexpected status: %s", n.url, res.Status)
+	}
+	return nil
+}
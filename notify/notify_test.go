@@ -0,0 +1,177 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mpolden/fs/sql"
+)
+
+var testMatch = NewMatch(sql.Watch{ID: 1, Query: "foo"}, []sql.Dir{
+	{Site: "site1", Path: "/foo/bar", Size: 42, Modified: 1514764800, FirstSeen: 1514851200},
+	{Site: "site2", Path: "/baz/foo"},
+})
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func readMatches(t *testing.T, data []byte) []Match {
+	var matches []Match
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var m Match
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatal(err)
+		}
+		matches = append(matches, m)
+	}
+	return matches
+}
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out bool
+	}{
+		{"stdout", true},
+		{"stdout:foo", false},
+		{"command:cat", true},
+		{"command:", false},
+		{"file:/tmp/fs.log", true},
+		{"file", false},
+		{"webhook:http://localhost:8080/hook", true},
+		{"webhook:localhost", false},
+		{"email:foo@example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		_, err := New(tt.in)
+		if got := err == nil; got != tt.out {
+			t.Errorf("New(%q) => %v, want valid=%t", tt.in, err, tt.out)
+		}
+	}
+}
+
+func TestMatchJSON(t *testing.T) {
+	data, err := json.Marshal(testMatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"watch":1,"query":"foo","dirs":[` +
+		`{"site":"site1","path":"/foo/bar","name":"bar","parent":"/foo","modified":"2018-01-01T00:00:00Z","size":42,"num_files":0,"first_seen":"2018-01-02T00:00:00Z"},` +
+		`{"site":"site2","path":"/baz/foo","name":"foo","parent":"/baz","modified":"1970-01-01T00:00:00Z","size":0,"num_files":0}]}`
+	if got := string(data); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).Notify(testMatch); err != nil {
+		t.Fatal(err)
+	}
+	want := `Watch 1 ("foo") matched 2 new directories:
+  site1 /foo/bar
+  site2 /baz/foo
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "out.json")
+	n, err := New("command:cat > " + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(testMatch); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readMatches(t, data); !reflect.DeepEqual(got, []Match{testMatch}) {
+		t.Errorf("got %+v, want %+v", got, testMatch)
+	}
+	n, err = New("command:echo failed; exit 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(testMatch); err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Errorf("want error containing command output, got %v", err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	n, err := New("file:" + filepath.Join(dir, "matches.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := n.Notify(testMatch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "matches.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readMatches(t, data); !reflect.DeepEqual(got, []Match{testMatch, testMatch}) {
+		t.Errorf("got %+v, want two matches", got)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got Match
+	var contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	n, err := New("webhook:" + srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(testMatch); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, testMatch) {
+		t.Errorf("got %+v, want %+v", got, testMatch)
+	}
+	if want := "application/json"; contentType != want {
+		t.Errorf("got Content-Type %q, want %q", contentType, want)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	n, err = New("webhook:" + failing.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(testMatch); err == nil {
+		t.Error("want error for failing webhook")
+	}
+}
@@ -0,0 +1,57 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ftsSchema creates the full-text indexes as external content tables over dir_terms and file_terms.
+const ftsSchema = `
+CREATE VIRTUAL TABLE dir_fts USING fts5(path, name, content='dir_terms', content_rowid='id');
+
+CREATE TRIGGER dir_terms_ai AFTER INSERT ON dir_terms BEGIN
+  INSERT INTO dir_fts(rowid, path, name) VALUES (new.id, new.path, new.name);
+END;
+
+CREATE TRIGGER dir_terms_ad AFTER DELETE ON dir_terms BEGIN
+  INSERT INTO dir_fts(dir_fts, rowid, path, name) VALUES ('delete', old.id, old.path, old.name);
+END;
+
+CREATE TRIGGER dir_terms_au AFTER UPDATE ON dir_terms BEGIN
+  INSERT INTO dir_fts(dir_fts, rowid, path, name) VALUES ('delete', old.id, old.path, old.name);
+  INSERT INTO dir_fts(rowid, path, name) VALUES (new.id, new.path, new.name);
+END;
+
+CREATE VIRTUAL TABLE file_fts USING fts5(name, content='file_terms', content_rowid='id');
+
+CREATE TRIGGER file_terms_ai AFTER INSERT ON file_terms BEGIN
+  INSERT INTO file_fts(rowid, name) VALUES (new.id, new.name);
+END;
+
+CREATE TRIGGER file_terms_ad AFTER DELETE ON file_terms BEGIN
+  INSERT INTO file_fts(file_fts, rowid, name) VALUES ('delete', old.id, old.name);
+END;
+
+CREATE TRIGGER file_terms_au AFTER UPDATE ON file_terms BEGIN
+  INSERT INTO file_fts(file_fts, rowid, name) VALUES ('delete', old.id, old.name);
+  INSERT INTO file_fts(rowid, name) VALUES (new.id, new.name);
+END;
+`
+
+// rankExpr returns an expression for the BM25 score of a match in the FTS table. Lower is better.
+func rankExpr(table string) string { return "bm25(" + table + ")" }
+
+// phrase returns words as a phrase query, optionally matching the last word as a prefix. The words are written as a
+// string, in which the tokenizer splits them on any punctuation.
+func phrase(words string, prefix bool) string {
+	s := `"` + strings.Replace(words, `"`, `""`, -1) + `"`
+	if prefix {
+		return s + "*"
+	}
+	return s
+}
+
+// near returns a query matching terms at most distance words apart.
+func near(terms []string, distance int) string {
+	return fmt.Sprintf("NEAR(%s, %d)", strings.Join(terms, " "), distance)
+}
@@ -0,0 +1,9 @@
+//go:build !sqlite_fts5 && !fts5
+// +build !sqlite_fts5,!fts5
+
+package sql
+
+// The search index uses FTS5, which go-sqlite3 only compiles into SQLite when built with the sqlite_fts5 tag. This
+// file stops builds without the tag, which would otherwise fail at runtime with "no such module: fts5". Build with
+// make install, or go build -tags sqlite_fts5.
+var _ = fs_must_be_built_with_tag_sqlite_fts5
@@ -0,0 +1,120 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("file is locked")
+
+// Lock is an advisory lock on a database, held by the process updating it. The mutex in Client only serializes
+// writes within a process, so processes writing to the same database must hold the lock.
+//
+// The lock is an exclusive lock on a file next to the database, which contains the PID of its holder. The operating
+// system releases the lock when its holder exits, so a lock file left behind by a process that did not release it is
+// taken over by the next process acquiring it. See lockFile for how the file is locked on each platform.
+type Lock struct {
+	name string
+	file *os.File
+}
+
+// LockedError is returned when a database is locked by another running process.
+type LockedError struct {
+	Name string
+	PID  int
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("database is locked by process %d: %s", e.PID, e.Name)
+}
+
+// LockFile returns the name of the lock file for the database in filename.
+func LockFile(filename string) string { return filename + ".lock" }
+
+// AcquireLock acquires the lock of the database in filename. A *LockedError is returned if another running process
+// holds the lock. In-memory databases are never locked.
+func AcquireLock(filename string) (*Lock, error) {
+	if filename == ":memory:" {
+		return &Lock{}, nil
+	}
+	name := LockFile(filename)
+	for {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(f); err != nil {
+			defer f.Close()
+			if err == errLocked {
+				return nil, &LockedError{Name: name, PID: readPID(f)}
+			}
+			return nil, err
+		}
+		// The holder removes the file when releasing the lock. If that happened after we opened it, we hold the lock
+		// of a file other processes no longer see, and must start over
+		same, err := isLockFile(f, name)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if !same {
+			f.Close()
+			continue
+		}
+		if err := writePID(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &Lock{name: name, file: f}, nil
+	}
+}
+
+// isLockFile returns whether f is the file currently named name.
+func isLockFile(f *os.File, name string) (bool, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	current, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(fi, current), nil
+}
+
+func writePID(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return err
+}
+
+// readPID returns the PID written in the lock file f, or 0 if it does not contain one.
+func readPID(f *os.File) int {
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// Release releases the lock.
+func (l *Lock) Release() error {
+	if l.file == nil {
+		return nil
+	}
+	return unlockFile(l.file, l.name)
+}
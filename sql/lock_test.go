@@ -0,0 +1,141 @@
+package sql
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := filepath.Join(dir, "fs.db")
+	lock, err := AcquireLock(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = AcquireLock(db)
+	if e, ok := err.(*LockedError); !ok || e.PID != os.Getpid() || e.Name != LockFile(db) {
+		t.Fatalf("got %v, want LockedError for PID %d", err, os.Getpid())
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = AcquireLock(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	// Stale locks are taken over
+	for _, content := range []string{"4194305\n", "", "garbage"} {
+		if err := ioutil.WriteFile(LockFile(db), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		lock, err := AcquireLock(db)
+		if err != nil {
+			t.Fatalf("stale lock %q: %s", content, err)
+		}
+		if data, err := ioutil.ReadFile(LockFile(db)); err != nil || string(data) != fmt.Sprintf("%d\n", os.Getpid()) {
+			t.Errorf("got lock file %q, want PID %d", data, os.Getpid())
+		}
+		if err := lock.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got %d files left behind, want 0", len(files))
+	}
+}
+
+// TestLockProcess is run as a separate process by TestLockRace. It acquires the lock once the start file exists, and
+// holds it for a while.
+func TestLockProcess(t *testing.T) {
+	db := os.Getenv("FS_TEST_LOCK")
+	if db == "" {
+		t.Skip("only run by TestLockRace")
+	}
+	for {
+		if _, err := os.Stat(db + ".start"); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	lock, err := AcquireLock(db)
+	if err != nil {
+		fmt.Println("failed:", err)
+		return
+	}
+	fmt.Println("acquired")
+	time.Sleep(time.Second)
+	lock.Release()
+}
+
+func TestLockRace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := filepath.Join(dir, "fs.db")
+	// A stale lock, which all processes try to take over at the same time
+	if err := ioutil.WriteFile(LockFile(db), []byte("4194305\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var outputs []*strings.Builder
+	var cmds []*exec.Cmd
+	for i := 0; i < 4; i++ {
+		var out strings.Builder
+		cmd := exec.Command(os.Args[0], "-test.run=^TestLockProcess$")
+		cmd.Env = append(os.Environ(), "FS_TEST_LOCK="+db)
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, &out)
+		cmds = append(cmds, cmd)
+	}
+	if err := ioutil.WriteFile(db+".start", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	acquired := 0
+	for i, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			t.Fatalf("process %d: %s: %s", i, err, outputs[i])
+		}
+		out := outputs[i].String()
+		switch {
+		case strings.Contains(out, "acquired"):
+			acquired++
+		case !strings.Contains(out, "database is locked by process"):
+			t.Errorf("process %d: got %q, want lock to be acquired or held by another process", i, out)
+		}
+	}
+	if acquired != 1 {
+		t.Errorf("got %d processes holding the lock, want 1", acquired)
+	}
+}
+
+func TestLockMemory(t *testing.T) {
+	lock, err := AcquireLock(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package sql
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile locks f with flock(2), or returns errLocked if another process holds the lock.
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLocked
+	}
+	return err
+}
+
+// unlockFile releases the lock of f, which is named name. The file is removed before the lock is released, so that no
+// process can acquire the lock of a file that is about to be removed.
+func unlockFile(f *os.File, name string) error {
+	err := os.Remove(name)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,36 @@
+package sql
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// lockFile locks f with LockFileEx, or returns errLocked if another process holds the lock. The locked byte is far
+// past the PID, which other processes can therefore still read.
+func lockFile(f *os.File) error {
+	ol := syscall.Overlapped{OffsetHigh: 1}
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0,
+		uintptr(unsafe.Pointer(&ol)))
+	if r != 0 {
+		return nil
+	}
+	if err == errorLockViolation {
+		return errLocked
+	}
+	return err
+}
+
+// unlockFile releases the lock of f. Files that are open cannot be removed on Windows, so the lock file is kept, and
+// reused by the next process acquiring the lock.
+func unlockFile(f *os.File, name string) error {
+	return f.Close()
+}
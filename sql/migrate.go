@@ -0,0 +1,287 @@
+package sql
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Migration is a change to the database schema. The schema version of a database is stored in PRAGMA user_version
+// and equals the version of the last migration applied to it.
+type Migration struct {
+	Version     int
+	Description string
+	up          func(tx *sqlx.Tx) error
+}
+
+// Migrations must only ever be appended to. Databases created before versioning was introduced have version 0, but
+// may already contain any of the changes below. Migrations must therefore be safe to apply to such databases.
+var migrations = []Migration{
+	{1, "Create site, dir and FTS tables", execSQL(`
+CREATE TABLE IF NOT EXISTS site (
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  CONSTRAINT name_unique UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS dir (
+  id INTEGER PRIMARY KEY,
+  site_id INTEGER NOT NULL,
+  path TEXT NOT NULL,
+  modified INTEGER NOT NULL,
+  CONSTRAINT path_unique UNIQUE(site_id, path),
+  FOREIGN KEY(site_id) REFERENCES site(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS dir_site_id_idx ON dir (site_id);
+
+-- FTS index table
+CREATE VIRTUAL TABLE IF NOT EXISTS dir_fts USING fts4(
+  id INTEGER PRIMARY KEY,
+  site_id INTEGER NOT NULL,
+  path TEXT NOT NULL
+);
+
+-- Triggers to keep FTS table up to date
+CREATE TRIGGER IF NOT EXISTS dir_bd BEFORE DELETE ON dir BEGIN
+  DELETE FROM dir_fts WHERE docid=old.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS dir_ai AFTER INSERT ON dir BEGIN
+  INSERT INTO dir_fts(id, site_id, path) VALUES (new.id, new.site_id, new.path);
+END;
+`)},
+	{2, "Add table for regular files", execSQL(`
+CREATE TABLE IF NOT EXISTS file (
+  id INTEGER PRIMARY KEY,
+  site_id INTEGER NOT NULL,
+  path TEXT NOT NULL,
+  name TEXT NOT NULL,
+  size INTEGER NOT NULL,
+  owner TEXT NOT NULL,
+  mode INTEGER NOT NULL,
+  modified INTEGER NOT NULL,
+  CONSTRAINT file_path_unique UNIQUE(site_id, path),
+  FOREIGN KEY(site_id) REFERENCES site(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS file_site_id_idx ON file (site_id);
+
+-- FTS index table for file names
+CREATE VIRTUAL TABLE IF NOT EXISTS file_fts USING fts4(
+  name TEXT NOT NULL
+);
+
+CREATE TRIGGER IF NOT EXISTS file_bd BEFORE DELETE ON file BEGIN
+  DELETE FROM file_fts WHERE docid=old.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS file_ai AFTER INSERT ON file BEGIN
+  INSERT INTO file_fts(docid, name) VALUES (new.rowid, new.name);
+END;
+`)},
+	{3, "Add size and file count of directories", addColumns("dir",
+		"size INTEGER NOT NULL DEFAULT 0",
+		"num_files INTEGER NOT NULL DEFAULT 0",
+	)},
+	{4, "Add crawl history", execSQL(`
+-- Sites are referenced by name, so that crawls are recorded for sites that have no site row, such as one whose first
+-- crawl failed, and kept when fs gc removes a site
+CREATE TABLE IF NOT EXISTS crawl (
+  id INTEGER PRIMARY KEY,
+  site TEXT NOT NULL,
+  started INTEGER NOT NULL,
+  finished INTEGER NOT NULL,
+  outcome TEXT NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  num_dirs INTEGER NOT NULL DEFAULT 0,
+  delta INTEGER NOT NULL DEFAULT 0,
+  num_errors INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS crawl_site_started_idx ON crawl (site, started);
+`)},
+	{5, "Track when directories were first and last seen", steps(
+		addColumns("site", "updated INTEGER NOT NULL DEFAULT 0"),
+		addColumns("dir",
+			"first_seen INTEGER NOT NULL DEFAULT 0",
+			"last_seen INTEGER NOT NULL DEFAULT 0",
+			"deleted INTEGER",
+		),
+		execSQL("CREATE INDEX IF NOT EXISTS dir_first_seen_idx ON dir (first_seen);"),
+	)},
+	{6, "Add checkpoints for new directories", execSQL(`
+-- Time of last check for new directories, per user
+CREATE TABLE IF NOT EXISTS checkpoint (
+  user TEXT NOT NULL,
+  checked INTEGER NOT NULL,
+  CONSTRAINT user_unique UNIQUE (user)
+);
+`)},
+	{7, "Add saved searches", execSQL(`
+CREATE TABLE IF NOT EXISTS watch (
+  id INTEGER PRIMARY KEY,
+  query TEXT NOT NULL,
+  site TEXT NOT NULL DEFAULT '',
+  notify TEXT NOT NULL,
+  checked INTEGER NOT NULL
+);
+`)},
+	{8, "Index normalized terms of paths and names", reindex(IndexOptions{})},
+}
+
+func execSQL(query string) func(tx *sqlx.Tx) error {
+	return func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+func steps(fns ...func(tx *sqlx.Tx) error) func(tx *sqlx.Tx) error {
+	return func(tx *sqlx.Tx) error {
+		for _, fn := range fns {
+			if err := fn(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumns adds the given column definitions to table, skipping columns that already exist.
+func addColumns(table string, columns ...string) func(tx *sqlx.Tx) error {
+	return func(tx *sqlx.Tx) error {
+		var existing []struct {
+			Name string `db:"name"`
+		}
+		if err := tx.Select(&existing, fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table)); err != nil {
+			return err
+		}
+	outer:
+		for _, c := range columns {
+			var name string
+			fmt.Sscan(c, &name)
+			for _, e := range existing {
+				if e.Name == name {
+					continue outer
+				}
+			}
+			if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, c)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// IndexOptions control how paths are indexed for full-text search.
+type IndexOptions struct {
+	// Components indexes each component of a path separately, so that phrases and NEAR queries only match words
+	// within the same component
+	Components bool
+}
+
+// reindex returns a function recreating the terms tables and full-text indexes with opts, replacing those created by
+// earlier versions.
+func reindex(opts IndexOptions) func(tx *sqlx.Tx) error {
+	return func(tx *sqlx.Tx) error {
+		for _, query := range []string{dropIndexSchema, termsSchema(opts), ftsSchema,
+			"INSERT INTO dir_fts(dir_fts) VALUES ('rebuild')",
+			"INSERT INTO file_fts(file_fts) VALUES ('rebuild')",
+		} {
+			if _, err := tx.Exec(query); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Reindex recreates the full-text indexes from the stored paths, indexing them as given by opts. Pending migrations
+// are applied first.
+func (c *Client) Reindex(opts IndexOptions) error {
+	pending, err := c.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		if _, err := c.Migrate(); err != nil {
+			return err
+		}
+		// The index was just rebuilt with the default options
+		if opts == (IndexOptions{}) {
+			return nil
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.transact(reindex(opts))
+}
+
+// LatestVersion returns the schema version this version of fs understands.
+func LatestVersion() int { return migrations[len(migrations)-1].Version }
+
+// Version returns the schema version of the database.
+func (c *Client) Version() (int, error) {
+	var version int
+	if err := c.db.Get(&version, "PRAGMA user_version"); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// PendingMigrations returns the migrations that have not been applied to the database.
+func (c *Client) PendingMigrations() ([]Migration, error) {
+	version, err := c.Version()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all pending migrations and returns the ones that were applied. Each migration runs in its own
+// transaction.
+func (c *Client) Migrate() ([]Migration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending, err := c.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range pending {
+		if err := c.migrate(m); err != nil {
+			return pending[:i], fmt.Errorf("migration to version %d failed: %s", m.Version, err)
+		}
+	}
+	return pending, nil
+}
+
+func (c *Client) migrate(m Migration) error {
+	return c.transact(func(tx *sqlx.Tx) error {
+		if err := m.up(tx); err != nil {
+			return err
+		}
+		// PRAGMA does not support parameters
+		_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.Version))
+		return err
+	})
+}
+
+// transact runs fn in a transaction, which is committed if fn succeeds.
+func (c *Client) transact(fn func(tx *sqlx.Tx) error) error {
+	tx, err := c.db.Beginx()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,135 @@
+package sql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func tempDatabase(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "fs.db"), func() { os.RemoveAll(dir) }
+}
+
+func TestMigrate(t *testing.T) {
+	c := testClient()
+	version, err := c.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != LatestVersion() {
+		t.Errorf("got version %d, want %d", version, LatestVersion())
+	}
+	migrated, err := c.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrated) != 0 {
+		t.Errorf("got %d migrations for up-to-date database, want 0", len(migrated))
+	}
+}
+
+func TestMigrateUnversioned(t *testing.T) {
+	name, cleanup := tempDatabase(t)
+	defer cleanup()
+	// Database created before migrations existed, with some of the later columns already present
+	db, err := sqlx.Connect("sqlite3", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Including the FTS4 indexes, which are dropped when the index is rebuilt
+	tx := db.MustBegin()
+	for _, m := range migrations[:2] {
+		if err := m.up(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	db.MustExec("ALTER TABLE dir ADD COLUMN size INTEGER NOT NULL DEFAULT 0")
+	db.MustExec("INSERT INTO site (id, name) VALUES (1, 'foo')")
+	db.MustExec("INSERT INTO dir (site_id, path, modified, size) VALUES (1, '/foo/bar', 42, 1024)")
+	db.MustExec("PRAGMA user_version = 0")
+	db.Close()
+
+	c, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := c.PendingMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(migrations) {
+		t.Errorf("got %d pending migrations, want %d", len(pending), len(migrations))
+	}
+	migrated, err := c.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrated) != len(migrations) {
+		t.Errorf("got %d applied migrations, want %d", len(migrated), len(migrations))
+	}
+	dirs, err := c.SelectDirs(Query{Keywords: "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 || dirs[0].Path != "/foo/bar" || dirs[0].Size != 1024 {
+		t.Errorf("got %+v, want existing directory to be kept", dirs)
+	}
+}
+
+func TestOpenNewer(t *testing.T) {
+	name, cleanup := tempDatabase(t)
+	defer cleanup()
+	c, err := New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.db.MustExec("PRAGMA user_version = 1000")
+	c.db.Close()
+	_, err = New(name)
+	if err == nil || !strings.Contains(err.Error(), "newer than the supported version") {
+		t.Errorf("want error for newer database, got %v", err)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	name, cleanup := tempDatabase(t)
+	defer cleanup()
+	c, err := New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("site1", []Dir{{Path: "/foo"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	ro, err := OpenReadOnly(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := ro.SelectDirs(Query{Keywords: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 {
+		t.Errorf("SelectDirs => %d dirs, want 1", len(dirs))
+	}
+	if err := ro.Insert("site1", []Dir{{Path: "/bar"}}, nil); err == nil {
+		t.Error("want error when writing to read-only database")
+	}
+	if _, err := c.db.Exec("PRAGMA user_version = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenReadOnly(name); err == nil || !strings.Contains(err.Error(), "pending migrations") {
+		t.Errorf("OpenReadOnly = %v, want error about pending migrations", err)
+	}
+}
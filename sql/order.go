@@ -0,0 +1,224 @@
+package sql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Nulls controls where NULL values are placed when sorting.
+type Nulls int
+
+const (
+	// NullsDefault sorts NULL values like SQLite does, before all other values in ascending order.
+	NullsDefault Nulls = iota
+	NullsFirst
+	NullsLast
+)
+
+// Order is a field to sort search results by.
+type Order struct {
+	Field      string
+	Descending bool
+	Nulls      Nulls
+}
+
+// orderFields are the fields results can be sorted by, and whether they only exist for directories.
+var orderFields = map[string]bool{
+	"depth":      false,
+	"first_seen": true,
+	"last_seen":  true,
+	"modified":   false,
+	"name":       false,
+	"path":       false,
+	"rank":       false,
+	"site":       false,
+	"size":       false,
+}
+
+var orderAliases = map[string]string{
+	"date":      "modified",
+	"mtime":     "modified",
+	"relevance": "rank",
+	"score":     "rank",
+	"basename":  "name",
+	"seen":      "first_seen",
+}
+
+func orderFieldNames() string {
+	var names []string
+	for name := range orderFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// ParseOrder parses an order on the form FIELD[:asc|desc][:nulls-first|nulls-last], such as size:desc:nulls-last.
+func ParseOrder(s string) (Order, error) {
+	parts := strings.Split(s, ":")
+	field := strings.ToLower(strings.TrimSpace(parts[0]))
+	if alias, ok := orderAliases[field]; ok {
+		field = alias
+	}
+	if _, ok := orderFields[field]; !ok {
+		return Order{}, fmt.Errorf("invalid order field %q: must be one of %s", parts[0], orderFieldNames())
+	}
+	o := Order{Field: field}
+	if len(parts) > 3 {
+		return Order{}, fmt.Errorf("invalid order %q: too many options", s)
+	}
+	seenDirection := false
+	for _, opt := range parts[1:] {
+		switch strings.ToLower(opt) {
+		case "asc", "desc":
+			if seenDirection {
+				return Order{}, fmt.Errorf("invalid order %q: direction given twice", s)
+			}
+			seenDirection = true
+			o.Descending = strings.ToLower(opt) == "desc"
+		case "nulls-first", "nulls-last":
+			if o.Nulls != NullsDefault {
+				return Order{}, fmt.Errorf("invalid order %q: nulls given twice", s)
+			}
+			o.Nulls = NullsFirst
+			if strings.ToLower(opt) == "nulls-last" {
+				o.Nulls = NullsLast
+			}
+		default:
+			return Order{}, fmt.Errorf("invalid order %q: %q must be asc, desc, nulls-first or nulls-last", s, opt)
+		}
+	}
+	return o, nil
+}
+
+// ParseOrders parses each of ss with ParseOrder.
+func ParseOrders(ss []string) ([]Order, error) {
+	var orders []Order
+	for _, s := range ss {
+		o, err := ParseOrder(s)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, nil
+}
+
+// orderColumn returns the expression sorting table by field.
+func orderColumn(field, table string) (string, error) {
+	dirOnly, ok := orderFields[field]
+	if !ok {
+		return "", fmt.Errorf("invalid order field %q: must be one of %s", field, orderFieldNames())
+	}
+	if dirOnly && table != "dir" {
+		return "", fmt.Errorf("cannot order %ss by %s", table, field)
+	}
+	switch field {
+	case "site":
+		return "site.name", nil
+	case "rank":
+		return "rank", nil
+	case "depth":
+		return depthExpr(table), nil
+	case "name":
+		if table == "dir" {
+			return "fs_name(dir.path)", nil
+		}
+	}
+	return table + "." + field, nil
+}
+
+// sortKey is an expression results are sorted by.
+type sortKey struct {
+	field  string
+	expr   string
+	desc   bool
+	isNull bool // Whether expr tests if field is NULL
+}
+
+// sortKeys returns the keys sorting table by orders. The SQLite version in use does not support NULLS FIRST and
+// NULLS LAST, so they are emulated by sorting on whether the value is NULL first. Results are finally sorted by id,
+// which makes the order stable across pages.
+func sortKeys(orders []Order, table string) ([]sortKey, error) {
+	var keys []sortKey
+	for _, o := range orders {
+		column, err := orderColumn(o.Field, table)
+		if err != nil {
+			return nil, err
+		}
+		if o.Nulls != NullsDefault {
+			keys = append(keys, sortKey{field: o.Field, expr: column + " IS NULL", desc: o.Nulls == NullsFirst, isNull: true})
+		}
+		keys = append(keys, sortKey{field: o.Field, expr: column, desc: o.Descending})
+	}
+	return append(keys, sortKey{field: "id", expr: table + ".id"}), nil
+}
+
+// orderBy returns the ORDER BY clause of keys.
+func orderBy(keys []sortKey) string {
+	var clauses []string
+	for _, k := range keys {
+		direction := "ASC"
+		if k.desc {
+			direction = "DESC"
+		}
+		clauses = append(clauses, k.expr+" "+direction)
+	}
+	return strings.Join(clauses, ", ")
+}
+
+// sortValue returns the value of field in d.
+func sortValue(field string, d *Dir) interface{} {
+	switch field {
+	case "id":
+		return d.ID
+	case "site":
+		return d.Site
+	case "path":
+		return d.Path
+	case "name":
+		return name(d.Path)
+	case "depth":
+		return strings.Count(d.Path, "/")
+	case "modified":
+		return d.Modified
+	case "size":
+		return d.Size
+	case "first_seen":
+		return d.FirstSeen
+	case "last_seen":
+		return d.LastSeen
+	case "rank":
+		return d.Rank
+	}
+	return nil
+}
+
+// afterCond returns a condition matching results sorted after d by keys. rank is the expression the rank key
+// refers to. Arguments of the condition are appended to args.
+func afterCond(keys []sortKey, d *Dir, rank string, args []interface{}) (string, []interface{}) {
+	var (
+		alts []string
+		eqs  []string
+	)
+	for _, k := range keys {
+		expr := k.expr
+		if k.field == "rank" && !k.isNull {
+			expr = rank
+		}
+		var value interface{} = 0 // Values of a Dir are never NULL
+		if !k.isNull {
+			value = sortValue(k.field, d)
+		}
+		args = append(args, value)
+		op := ">"
+		if k.desc {
+			op = "<"
+		}
+		alt := append(eqs[:len(eqs):len(eqs)], fmt.Sprintf("%s %s $%d", expr, op, len(args)))
+		alts = append(alts, strings.Join(alt, " AND "))
+		eqs = append(eqs, fmt.Sprintf("%s = $%d", expr, len(args)))
+	}
+	return "(" + strings.Join(alts, " OR ") + ")", args
+}
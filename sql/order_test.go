@@ -0,0 +1,265 @@
+package sql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOrder(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out Order
+		err string
+	}{
+		{"site", Order{Field: "site"}, ""},
+		{"Path:ASC", Order{Field: "path"}, ""},
+		{"size:desc", Order{Field: "size", Descending: true}, ""},
+		{"date:desc", Order{Field: "modified", Descending: true}, ""},
+		{"relevance", Order{Field: "rank"}, ""},
+		{"size:desc:nulls-first", Order{Field: "size", Descending: true, Nulls: NullsFirst}, ""},
+		{"size:nulls-last", Order{Field: "size", Nulls: NullsLast}, ""},
+		{"", Order{}, `invalid order field "": must be one of depth, first_seen, last_seen, modified, name, path, rank, site, size`},
+		{"dir.path", Order{}, `invalid order field "dir.path"`},
+		{"path; DROP TABLE dir", Order{}, `invalid order field "path; DROP TABLE dir"`},
+		{"path:sideways", Order{}, `"sideways" must be asc, desc, nulls-first or nulls-last`},
+		{"path:asc:desc", Order{}, "direction given twice"},
+		{"path:nulls-first:nulls-last", Order{}, "nulls given twice"},
+		{"path:asc:nulls-last:foo", Order{}, "too many options"},
+	}
+	for _, tt := range tests {
+		got, err := ParseOrder(tt.in)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseOrder(%q) = %v, want error containing %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.out {
+			t.Errorf("ParseOrder(%q) => %+v, want %+v", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestParseOrders(t *testing.T) {
+	got, err := ParseOrders([]string{"site", "modified:desc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Order{{Field: "site"}, {Field: "modified", Descending: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseOrders => %+v, want %+v", got, want)
+	}
+	if _, err := ParseOrders([]string{"site", "foo"}); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestSortKeys(t *testing.T) {
+	var tests = []struct {
+		orders []Order
+		table  string
+		out    string
+		err    string
+	}{
+		{nil, "dir", "dir.id ASC", ""},
+		{[]Order{{Field: "site"}, {Field: "path", Descending: true}}, "dir", "site.name ASC, dir.path DESC, dir.id ASC", ""},
+		{[]Order{{Field: "name"}}, "dir", "fs_name(dir.path) ASC, dir.id ASC", ""},
+		{[]Order{{Field: "name"}}, "file", "file.name ASC, file.id ASC", ""},
+		{[]Order{{Field: "depth", Descending: true}}, "file", depthExpr("file") + " DESC, file.id ASC", ""},
+		{[]Order{{Field: "size", Nulls: NullsFirst}}, "dir", "dir.size IS NULL DESC, dir.size ASC, dir.id ASC", ""},
+		{[]Order{{Field: "size", Descending: true, Nulls: NullsLast}}, "dir", "dir.size IS NULL ASC, dir.size DESC, dir.id ASC", ""},
+		{[]Order{{Field: "first_seen"}}, "file", "", "cannot order files by first_seen"},
+		{[]Order{{Field: "dir.path"}}, "dir", "", `invalid order field "dir.path"`},
+	}
+	for _, tt := range tests {
+		keys, err := sortKeys(tt.orders, tt.table)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("sortKeys(%+v, %q) = %v, want error containing %q", tt.orders, tt.table, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := orderBy(keys); got != tt.out {
+			t.Errorf("orderBy(%+v, %q) => %q, want %q", tt.orders, tt.table, got, tt.out)
+		}
+	}
+}
+
+func TestAfterCond(t *testing.T) {
+	keys, err := sortKeys([]Order{{Field: "site"}, {Field: "size", Descending: true, Nulls: NullsLast}}, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cond, args := afterCond(keys, &Dir{ID: 3, Site: "foo", Size: 42}, "0", []interface{}{"bar"})
+	want := "(site.name > $2" +
+		" OR site.name = $2 AND dir.size IS NULL > $3" +
+		" OR site.name = $2 AND dir.size IS NULL = $3 AND dir.size < $4" +
+		" OR site.name = $2 AND dir.size IS NULL = $3 AND dir.size = $4 AND dir.id > $5)"
+	wantArgs := []interface{}{"bar", "foo", 0, int64(42), int64(3)}
+	if cond != want || !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("afterCond => (%q, %v), want (%q, %v)", cond, args, want, wantArgs)
+	}
+}
+
+func TestSelectDirsOrder(t *testing.T) {
+	c := testClient()
+	dirs := []Dir{{Path: "/b/c", Size: 2}, {Path: "/a", Size: 3}, {Path: "/c/a/b", Size: 1}}
+	if err := c.Insert("site1", dirs, nil); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		order []Order
+		out   []string
+	}{
+		{[]Order{{Field: "path"}}, []string{"/a", "/b/c", "/c/a/b"}},
+		{[]Order{{Field: "name"}}, []string{"/a", "/c/a/b", "/b/c"}},
+		{[]Order{{Field: "name", Descending: true}}, []string{"/b/c", "/c/a/b", "/a"}},
+		{[]Order{{Field: "depth", Descending: true}, {Field: "path"}}, []string{"/c/a/b", "/b/c", "/a"}},
+		{[]Order{{Field: "size", Descending: true}}, []string{"/a", "/b/c", "/c/a/b"}},
+	}
+	for _, tt := range tests {
+		got, err := c.SelectDirs(Query{Order: tt.order})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, d := range got {
+			out = append(out, d.Path)
+		}
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("SelectDirs(%+v) => %q, want %q", tt.order, out, tt.out)
+		}
+	}
+	if _, err := c.SelectFiles(Query{Order: []Order{{Field: "last_seen"}}}); err == nil {
+		t.Error("want error when ordering files by last_seen")
+	}
+}
+
+func TestSelectDirsPages(t *testing.T) {
+	c := testClient()
+	dirs := []Dir{
+		{Path: "/tv/Some.Show.S01E01", Size: 2},
+		{Path: "/tv/Some.Show.S01E02", Size: 1},
+		{Path: "/tv/Some.Show.S01E03", Size: 2},
+		{Path: "/tv/Some.Show.Some.Extras", Size: 3},
+		{Path: "/tv/Other.Show", Size: 2},
+	}
+	if err := c.Insert("site1", dirs, nil); err != nil {
+		t.Fatal(err)
+	}
+	paths := func(dirs []Dir) []string {
+		var paths []string
+		for _, d := range dirs {
+			paths = append(paths, d.Path)
+		}
+		return paths
+	}
+	var tests = []struct {
+		keywords string
+		order    []Order
+	}{
+		{"", []Order{{Field: "path"}}},
+		{"", []Order{{Field: "size", Descending: true}}},
+		{"show", []Order{{Field: "rank"}}},
+		{"show", []Order{{Field: "size"}, {Field: "name", Descending: true, Nulls: NullsFirst}}},
+	}
+	for _, tt := range tests {
+		q := Query{Keywords: tt.keywords, Order: tt.order}
+		all, err := c.SelectDirs(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != len(dirs) {
+			t.Fatalf("SelectDirs(%+v) => %d dirs, want %d", q, len(all), len(dirs))
+		}
+		var byOffset, byKey []Dir
+		q.Limit = 2
+		for {
+			page, err := c.SelectDirs(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			byOffset = append(byOffset, page...)
+			q.Offset += q.Limit
+		}
+		q.Offset = 0
+		for {
+			page, err := c.SelectDirs(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			byKey = append(byKey, page...)
+			q.After = &page[len(page)-1]
+		}
+		if !reflect.DeepEqual(paths(byOffset), paths(all)) {
+			t.Errorf("SelectDirs(%+v) by offset => %q, want %q", tt.order, paths(byOffset), paths(all))
+		}
+		if !reflect.DeepEqual(paths(byKey), paths(all)) {
+			t.Errorf("SelectDirs(%+v) after key => %q, want %q", tt.order, paths(byKey), paths(all))
+		}
+	}
+	dirs, err := c.SelectDirs(Query{Offset: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 {
+		t.Errorf("SelectDirs with offset and no limit => %d dirs, want 1", len(dirs))
+	}
+}
+
+func TestQueryDirs(t *testing.T) {
+	c := testClient()
+	if err := c.Insert("site1", []Dir{{Path: "/foo"}, {Path: "/bar"}}, []File{{Dir: Dir{Path: "/foo/baz"}, Owner: "foo"}}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := c.QueryDirs(Query{Order: []Order{{Field: "path"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var d Dir
+		if err := rows.Scan(&d); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, d.Path)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/bar", "/foo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryDirs => %q, want %q", got, want)
+	}
+	files, err := c.QueryFiles(Query{Keywords: "baz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.Close()
+	var f File
+	if !files.Next() {
+		t.Fatalf("QueryFiles => no results, want 1: %v", files.Err())
+	}
+	if err := files.Scan(&f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Path != "/foo/baz" || f.Owner != "foo" {
+		t.Errorf("QueryFiles => %+v, want /foo/baz owned by foo", f)
+	}
+	if files.Next() {
+		t.Error("QueryFiles => more than 1 result")
+	}
+}
@@ -0,0 +1,564 @@
+package sql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// fields are the filters supported in queries. Text fields are matched against the full-text index.
+var fields = map[string]bool{
+	"after":  false,
+	"before": false,
+	"depth":  false,
+	"name":   true,
+	"path":   false,
+	"site":   false,
+}
+
+const defaultNearDistance = 10
+
+// SyntaxError is returned for queries that cannot be parsed.
+type SyntaxError struct {
+	Query string
+	Pos   int
+	Msg   string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("invalid query %q at position %d: %s", e.Query, e.Pos+1, e.Msg)
+}
+
+type node interface{}
+
+// termNode is a word or phrase, optionally restricted to a column of the full-text index.
+type termNode struct {
+	column string
+	words  string
+	prefix bool
+}
+
+type nearNode struct {
+	terms    []termNode
+	distance int
+}
+
+type fieldNode struct {
+	field string
+	op    string
+	value interface{}
+}
+
+type notNode struct{ node node }
+
+type andNode []node
+
+type orNode []node
+
+type parser struct {
+	query string
+	runes []rune
+	pos   int
+}
+
+// ParseQuery parses a search query. Queries consist of words, which are matched against the full-text index, and
+// field filters:
+//
+//	ubuntu 18.04            directories matching both words
+//	"some show"             phrase
+//	ubuntu*                 words starting with ubuntu
+//	NEAR(ubuntu amd64, 3)   words at most 3 words apart
+//	name:s01e02             words in the last component of the path
+//	site:foo                directories on site foo
+//	after:2018-01-01        directories modified at or after the given date
+//	before:2018-02-01       directories modified before the given date
+//	path:/tv/               paths starting with /tv/, or containing the value if it does not start with /
+//	depth:2, depth:>2       paths with the given number of components
+//	-foo, -site:foo         negation
+//	foo OR bar              either query. Terms are otherwise combined with AND
+//	(foo OR bar) baz        grouping
+//
+// It returns nil if the query is empty.
+func ParseQuery(query string) (node, error) {
+	p := &parser{query: query, runes: []rune(query)}
+	p.skipSpace()
+	if p.eof() {
+		return nil, nil
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q", p.runes[p.pos])
+	}
+	return n, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Query: p.query, Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) eof() bool { return p.pos >= len(p.runes) }
+
+func (p *parser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	return p.runes[p.pos]
+}
+
+func (p *parser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(p.peek()) {
+		p.pos++
+	}
+}
+
+// isKeyword returns whether the next word is the given keyword.
+func (p *parser) isKeyword(kw string) bool {
+	end := p.pos + len(kw)
+	if end > len(p.runes) || string(p.runes[p.pos:end]) != kw {
+		return false
+	}
+	return end == len(p.runes) || unicode.IsSpace(p.runes[end]) || p.runes[end] == '('
+}
+
+// keyword consumes the next word if it is the given keyword.
+func (p *parser) keyword(kw string) bool {
+	if !p.isKeyword(kw) {
+		return false
+	}
+	p.pos += len(kw)
+	p.skipSpace()
+	return true
+}
+
+func (p *parser) parseOr() (node, error) {
+	var or orNode
+	for {
+		start := p.pos
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if n == nil {
+			if len(or) == 0 {
+				return nil, p.errorf("missing query before %s", p.word())
+			}
+			p.pos = start
+			return nil, p.errorf("missing query after OR")
+		}
+		or = append(or, n)
+		if !p.keyword("OR") {
+			break
+		}
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	var and andNode
+	for !p.eof() && p.peek() != ')' && !p.isKeyword("OR") {
+		if p.keyword("AND") {
+			if len(and) == 0 {
+				return nil, p.errorf("missing query before AND")
+			}
+			continue
+		}
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, n)
+		p.skipSpace()
+	}
+	switch len(and) {
+	case 0:
+		return nil, nil
+	case 1:
+		return and[0], nil
+	}
+	return and, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek() != '-' {
+		return p.parsePrimary()
+	}
+	p.pos++
+	if p.eof() || unicode.IsSpace(p.peek()) {
+		return nil, p.errorf("missing query after -")
+	}
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	return notNode{n}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	switch p.peek() {
+	case '(':
+		start := p.pos
+		p.pos++
+		p.skipSpace()
+		if p.peek() == ')' {
+			return nil, p.errorf("empty parentheses")
+		}
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			p.pos = start
+			return nil, p.errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return n, nil
+	case ')':
+		return nil, p.errorf("unexpected )")
+	case '"':
+		return p.parseTerm("")
+	}
+	if p.keyword("NEAR") {
+		return p.parseNear()
+	}
+	start := p.pos
+	word := p.word()
+	if i := strings.Index(word, ":"); i > 0 {
+		field := strings.ToLower(word[:i])
+		text, ok := fields[field]
+		if !ok {
+			return nil, p.errorf("unknown field %q, valid fields are: after, before, depth, name, path, site. Use quotes to search for %q", field, word)
+		}
+		p.pos = start + len([]rune(word[:i])) + 1
+		if p.eof() || unicode.IsSpace(p.peek()) || p.peek() == ')' {
+			return nil, p.errorf("missing value for %s", field)
+		}
+		if text {
+			return p.parseTerm(field)
+		}
+		return p.parseField(field)
+	}
+	p.pos = start
+	return p.parseTerm("")
+}
+
+// word returns the next word without consuming it.
+func (p *parser) word() string {
+	end := p.pos
+	for end < len(p.runes) && !unicode.IsSpace(p.runes[end]) && p.runes[end] != '(' && p.runes[end] != ')' {
+		end++
+	}
+	return string(p.runes[p.pos:end])
+}
+
+// value consumes a quoted string or a word.
+func (p *parser) value() (string, bool, error) {
+	if p.peek() != '"' {
+		w := p.word()
+		p.pos += len([]rune(w))
+		return w, false, nil
+	}
+	start := p.pos
+	p.pos++
+	end := p.pos
+	for end < len(p.runes) && p.runes[end] != '"' {
+		end++
+	}
+	if end == len(p.runes) {
+		p.pos = start
+		return "", false, p.errorf("missing closing quote")
+	}
+	s := string(p.runes[p.pos:end])
+	p.pos = end + 1
+	return s, true, nil
+}
+
+// isWordRune returns whether r is part of a word in the full-text index. Other characters separate words.
+func isWordRune(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }
+
+func (p *parser) parseTerm(column string) (node, error) {
+	start := p.pos
+	s, quoted, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	prefix := false
+	if p.peek() == '*' {
+		p.pos++
+		prefix = true
+	} else if !quoted && strings.HasSuffix(s, "*") {
+		s = strings.TrimSuffix(s, "*")
+		prefix = true
+	}
+	words := Terms(s)
+	// The full-text index only stores letters and numbers, and a term without any cannot match
+	if strings.IndexFunc(words, isWordRune) < 0 {
+		p.pos = start
+		return nil, p.errorf("%q contains no searchable words", s)
+	}
+	return termNode{column: column, words: words, prefix: prefix}, nil
+}
+
+func (p *parser) parseNear() (node, error) {
+	if p.peek() != '(' {
+		return nil, p.errorf("missing ( after NEAR")
+	}
+	start := p.pos
+	p.pos++
+	near := nearNode{distance: defaultNearDistance}
+	for {
+		p.skipSpace()
+		if p.eof() {
+			p.pos = start
+			return nil, p.errorf("missing closing parenthesis")
+		}
+		if p.peek() == ')' {
+			p.pos++
+			break
+		}
+		if p.peek() == ',' {
+			p.pos++
+			p.skipSpace()
+			w := p.word()
+			d, err := strconv.Atoi(strings.TrimSuffix(w, ","))
+			if err != nil || d < 0 {
+				return nil, p.errorf("invalid NEAR distance %q", w)
+			}
+			p.pos += len([]rune(w))
+			near.distance = d
+			p.skipSpace()
+			if p.peek() != ')' {
+				return nil, p.errorf("missing closing parenthesis after NEAR distance")
+			}
+			continue
+		}
+		n, err := p.parseNearTerm()
+		if err != nil {
+			return nil, err
+		}
+		near.terms = append(near.terms, n)
+	}
+	if len(near.terms) < 2 {
+		p.pos = start
+		return nil, p.errorf("NEAR needs at least two terms")
+	}
+	return near, nil
+}
+
+func (p *parser) parseNearTerm() (termNode, error) {
+	// Commas separate the distance in NEAR groups, so words end there
+	end := p.pos
+	if p.peek() != '"' {
+		for end < len(p.runes) && p.runes[end] != ',' && p.runes[end] != ')' && !unicode.IsSpace(p.runes[end]) {
+			end++
+		}
+		saved := p.runes
+		p.runes = p.runes[:end]
+		defer func() { p.runes = saved }()
+	}
+	n, err := p.parseTerm("")
+	if err != nil {
+		return termNode{}, err
+	}
+	return n.(termNode), nil
+}
+
+func (p *parser) parseField(field string) (node, error) {
+	start := p.pos
+	s, _, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	switch field {
+	case "after", "before":
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("invalid date %q for %s, want YYYY-MM-DD", s, field)
+		}
+		op := ">="
+		if field == "before" {
+			op = "<"
+		}
+		return fieldNode{field: "modified", op: op, value: t.Unix()}, nil
+	case "depth":
+		op := "="
+		for _, o := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(s, o) {
+				op = o
+				s = s[len(o):]
+				break
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			p.pos = start
+			return nil, p.errorf("invalid depth %q, want a number optionally preceded by <, <=, > or >=", s)
+		}
+		return fieldNode{field: field, op: op, value: n}, nil
+	case "path":
+		op := "contains"
+		if strings.HasPrefix(s, "/") {
+			op = "prefix"
+		}
+		return fieldNode{field: field, op: op, value: s}, nil
+	}
+	return fieldNode{field: field, op: "=", value: s}, nil
+}
+
+// isText returns whether n can be expressed as a full-text query.
+func isText(n node) bool {
+	switch n := n.(type) {
+	case termNode, nearNode:
+		return true
+	case andNode:
+		for _, c := range n {
+			if !isText(c) {
+				return false
+			}
+		}
+		return true
+	case orNode:
+		for _, c := range n {
+			if !isText(c) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// ftsTerm returns t as a phrase, so that punctuation in it is never interpreted as query syntax.
+func ftsTerm(t termNode) string {
+	s := phrase(t.words, t.prefix)
+	if t.column != "" {
+		s = t.column + ":" + s
+	}
+	return s
+}
+
+// ftsExpr returns the full-text query for n, which must satisfy isText.
+func ftsExpr(n node) string {
+	switch n := n.(type) {
+	case termNode:
+		return ftsTerm(n)
+	case nearNode:
+		var terms []string
+		for _, t := range n.terms {
+			terms = append(terms, ftsTerm(t))
+		}
+		return near(terms, n.distance)
+	case andNode:
+		var exprs []string
+		for _, c := range n {
+			exprs = append(exprs, ftsExpr(c))
+		}
+		return "(" + strings.Join(exprs, " AND ") + ")"
+	case orNode:
+		var exprs []string
+		for _, c := range n {
+			exprs = append(exprs, ftsExpr(c))
+		}
+		return "(" + strings.Join(exprs, " OR ") + ")"
+	}
+	panic(fmt.Sprintf("not a text query: %#v", n))
+}
+
+// compileQuery compiles n to a full-text query and SQL conditions on table. Arguments of the conditions are
+// appended to args, after the full-text query if there is one.
+func compileQuery(n node, table string, args []interface{}) (string, string, []interface{}) {
+	var conjuncts []node
+	if and, ok := n.(andNode); ok {
+		conjuncts = and
+	} else if n != nil {
+		conjuncts = []node{n}
+	}
+	var match []string
+	var rest []node
+	for _, c := range conjuncts {
+		if isText(c) {
+			match = append(match, ftsExpr(c))
+		} else {
+			rest = append(rest, c)
+		}
+	}
+	fts := ""
+	if len(match) > 0 {
+		fts = strings.Join(match, " AND ")
+		// Negated text can be excluded by the full-text query as long as it has some positive terms
+		var remaining []node
+		for _, c := range rest {
+			if not, ok := c.(notNode); ok && isText(not.node) {
+				fts = "(" + fts + ") NOT " + ftsExpr(not.node)
+			} else {
+				remaining = append(remaining, c)
+			}
+		}
+		rest = remaining
+		args = append(args, fts)
+	}
+	var conds []string
+	for _, c := range rest {
+		var cond string
+		cond, args = sqlExpr(c, table, args)
+		conds = append(conds, cond)
+	}
+	return fts, strings.Join(conds, " AND "), args
+}
+
+// sqlExpr returns an SQL condition for n on table.
+func sqlExpr(n node, table string, args []interface{}) (string, []interface{}) {
+	if isText(n) {
+		args = append(args, ftsExpr(n))
+		return fmt.Sprintf("%s.id IN (SELECT rowid FROM %s_fts WHERE %s_fts MATCH $%d)", table, table, table, len(args)), args
+	}
+	switch n := n.(type) {
+	case fieldNode:
+		args = append(args, n.value)
+		arg := fmt.Sprintf("$%d", len(args))
+		switch n.field {
+		case "site":
+			return "site.name = " + arg, args
+		case "modified":
+			return fmt.Sprintf("%s.modified %s %s", table, n.op, arg), args
+		case "depth":
+			return fmt.Sprintf("%s %s %s", depthExpr(table), n.op, arg), args
+		case "path":
+			if n.op == "prefix" {
+				return fmt.Sprintf("substr(%s.path, 1, length(%s)) = %s", table, arg, arg), args
+			}
+			return fmt.Sprintf("instr(%s.path, %s) > 0", table, arg), args
+		}
+	case notNode:
+		cond, args := sqlExpr(n.node, table, args)
+		return "NOT (" + cond + ")", args
+	case andNode, orNode:
+		children, op := []node(nil), " AND "
+		if and, ok := n.(andNode); ok {
+			children = and
+		} else {
+			children, op = n.(orNode), " OR "
+		}
+		var conds []string
+		for _, c := range children {
+			var cond string
+			cond, args = sqlExpr(c, table, args)
+			conds = append(conds, cond)
+		}
+		return "(" + strings.Join(conds, op) + ")", args
+	}
+	panic(fmt.Sprintf("unknown query node: %#v", n))
+}
+
+// depthExpr returns an expression for the number of components in the paths of table.
+func depthExpr(table string) string {
+	return fmt.Sprintf("length(%s.path) - length(replace(%s.path, '/', ''))", table, table)
+}
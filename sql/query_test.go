@@ -0,0 +1,184 @@
+package sql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileQuery(t *testing.T) {
+	date := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	var tests = []struct {
+		in    string
+		match string
+		cond  string
+		args  []interface{}
+	}{
+		{"", "", "", nil},
+		{"foo bar", `"foo" AND "bar"`, "", []interface{}{`"foo" AND "bar"`}},
+		{"S01E02 1080P", `"s01e02" AND "1080p"`, "", []interface{}{`"s01e02" AND "1080p"`}},
+		{"ubuntu 18.04", `"ubuntu" AND "18 04"`, "", []interface{}{`"ubuntu" AND "18 04"`}},
+		{"Some.Show*", `"some show"*`, "", []interface{}{`"some show"*`}},
+		{`"Foo" bar*`, `"foo" AND "bar"*`, "", []interface{}{`"foo" AND "bar"*`}},
+		{"name:Café", `name:"cafe"`, "", []interface{}{`name:"cafe"`}},
+		{"NEAR(foo 18.04, 3)", `NEAR("foo" "18 04", 3)`, "", []interface{}{`NEAR("foo" "18 04", 3)`}},
+		{"NEAR(foo bar)", `NEAR("foo" "bar", 10)`, "", []interface{}{`NEAR("foo" "bar", 10)`}},
+		{"foo OR bar", `("foo" OR "bar")`, "", []interface{}{`("foo" OR "bar")`}},
+		{"(foo OR bar) AND baz", `("foo" OR "bar") AND "baz"`, "", []interface{}{`("foo" OR "bar") AND "baz"`}},
+		{"foo -bar", `("foo") NOT "bar"`, "", []interface{}{`("foo") NOT "bar"`}},
+		{"-bar", "", "NOT (dir.id IN (SELECT rowid FROM dir_fts WHERE dir_fts MATCH $1))", []interface{}{`"bar"`}},
+		{"foo site:bar", `"foo"`, "site.name = $2", []interface{}{`"foo"`, "bar"}},
+		{"site:bar", "", "site.name = $1", []interface{}{"bar"}},
+		{"foo -site:bar", `"foo"`, "NOT (site.name = $2)", []interface{}{`"foo"`, "bar"}},
+		{"after:2018-01-01", "", "dir.modified >= $1", []interface{}{date}},
+		{"before:2018-01-01", "", "dir.modified < $1", []interface{}{date}},
+		{"path:/tv/", "", "substr(dir.path, 1, length($1)) = $1", []interface{}{"/tv/"}},
+		{`path:"some show"`, "", "instr(dir.path, $1) > 0", []interface{}{"some show"}},
+		{"depth:2", "", "length(dir.path) - length(replace(dir.path, '/', '')) = $1", []interface{}{2}},
+		{"depth:>=2", "", "length(dir.path) - length(replace(dir.path, '/', '')) >= $1", []interface{}{2}},
+		{"foo OR site:bar", "", "(dir.id IN (SELECT rowid FROM dir_fts WHERE dir_fts MATCH $1) OR site.name = $2)", []interface{}{`"foo"`, "bar"}},
+	}
+	for _, tt := range tests {
+		n, err := ParseQuery(tt.in)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q): %s", tt.in, err)
+		}
+		match, cond, args := compileQuery(n, "dir", nil)
+		if match != tt.match || cond != tt.cond || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("compileQuery(%q) = (%q, %q, %v), want (%q, %q, %v)", tt.in, match, cond, args, tt.match, tt.cond, tt.args)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	var tests = []struct {
+		in  string
+		err string
+	}{
+		{"(foo", "at position 1: missing closing parenthesis"},
+		{"foo)", "at position 4: unexpected ')'"},
+		{"()", "at position 2: empty parentheses"},
+		{`"foo`, "at position 1: missing closing quote"},
+		{"foo OR", "at position 7: missing query after OR"},
+		{"OR foo", "at position 1: missing query before OR"},
+		{"foo -", "at position 6: missing query after -"},
+		{"foo:bar", `unknown field "foo"`},
+		{"site:", "missing value for site"},
+		{"after:yesterday", `invalid date "yesterday" for after`},
+		{"depth:x", `invalid depth "x"`},
+		{"NEAR(foo)", "NEAR needs at least two terms"},
+		{"NEAR(foo bar, x)", `invalid NEAR distance "x"`},
+		{"...", `"..." contains no searchable words`},
+		{"'", `"'" contains no searchable words`},
+		{"foo @", `at position 5: "@" contains no searchable words`},
+	}
+	for _, tt := range tests {
+		_, err := ParseQuery(tt.in)
+		if _, ok := err.(*SyntaxError); !ok || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseQuery(%q) = %v, want syntax error containing %q", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestSelectPunctuation(t *testing.T) {
+	c := testClient()
+	dirs := []Dir{
+		{Path: "/music/Rock&Roll"},
+		{Path: "/books/C++.Primer"},
+		{Path: "/tv/It's.A.Show"},
+		{Path: "/misc/{xyz}"},
+	}
+	if err := c.Insert("site1", dirs, nil); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		query string
+		out   []string
+	}{
+		{"rock&roll", []string{"/music/Rock&Roll"}},
+		{"it's", []string{"/tv/It's.A.Show"}},
+		{"c++", []string{"/books/C++.Primer"}},
+		{"c++*", []string{"/books/C++.Primer"}},
+		{"{xyz}", []string{"/misc/{xyz}"}},
+		{"name:{xyz}", []string{"/misc/{xyz}"}},
+		{"NEAR(rock^ #roll, 1)", []string{"/music/Rock&Roll"}},
+		{"foo^", nil},
+		{"#x", nil},
+		{`foo"bar`, nil},
+	}
+	for _, tt := range tests {
+		got, err := c.SelectDirs(Query{Keywords: tt.query})
+		if err != nil {
+			t.Fatalf("SelectDirs(%q): %s", tt.query, err)
+		}
+		var out []string
+		for _, d := range got {
+			out = append(out, d.Path)
+		}
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("SelectDirs(%q) => %q, want %q", tt.query, out, tt.out)
+		}
+	}
+}
+
+func TestSelectQueryLanguage(t *testing.T) {
+	c := testClient()
+	dirs := []Dir{
+		{Path: "/tv/Some.Show.S01E01", Modified: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC).Unix()},
+		{Path: "/tv/Some.Show.S01E02", Modified: time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC).Unix()},
+		{Path: "/movies/Some.Movie", Modified: time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC).Unix()},
+		{Path: "/movies/Other.Movie/Extras", Modified: time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC).Unix()},
+	}
+	if err := c.Insert("site1", dirs, []File{{Dir: Dir{Path: "/tv/Some.Show.S01E01/some.show.mkv"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("site2", []Dir{{Path: "/tv/Other.Show"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		query string
+		out   []string
+	}{
+		{"some", []string{"site1 /movies/Some.Movie", "site1 /tv/Some.Show.S01E01", "site1 /tv/Some.Show.S01E02"}},
+		{"some -movie", []string{"site1 /tv/Some.Show.S01E01", "site1 /tv/Some.Show.S01E02"}},
+		{"show site:site2", []string{"site2 /tv/Other.Show"}},
+		{"path:/tv/ -site:site1", []string{"site2 /tv/Other.Show"}},
+		{"path:/movies/ depth:2", []string{"site1 /movies/Some.Movie"}},
+		{"path:Movie depth:>2", []string{"site1 /movies/Other.Movie/Extras"}},
+		{"show after:2018-01-15", []string{"site1 /tv/Some.Show.S01E02"}},
+		{"some before:2018-01-15", []string{"site1 /tv/Some.Show.S01E01"}},
+		{"s01e01 OR extras", []string{"site1 /movies/Other.Movie/Extras", "site1 /tv/Some.Show.S01E01"}},
+		{"s01e01 OR site:site2", []string{"site1 /tv/Some.Show.S01E01", "site2 /tv/Other.Show"}},
+		{`"some show"`, []string{"site1 /tv/Some.Show.S01E01", "site1 /tv/Some.Show.S01E02"}},
+		{`"show some"`, nil},
+		{"-show", []string{"site1 /movies/Other.Movie/Extras", "site1 /movies/Some.Movie"}},
+	}
+	for _, tt := range tests {
+		got, err := c.SelectDirs(Query{Keywords: tt.query, Order: []Order{{Field: "site"}, {Field: "path"}}})
+		if err != nil {
+			t.Fatalf("SelectDirs(%q): %s", tt.query, err)
+		}
+		var out []string
+		for _, d := range got {
+			out = append(out, d.Site+" "+d.Path)
+		}
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("SelectDirs(%q) => %q, want %q", tt.query, out, tt.out)
+		}
+	}
+	files, err := c.SelectFiles(Query{Keywords: "mkv path:/tv/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("SelectFiles => %d files, want 1", len(files))
+	}
+	files, err = c.SelectFiles(Query{Keywords: "site:site1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("SelectFiles without words => %d files, want 1", len(files))
+	}
+}
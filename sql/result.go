@@ -0,0 +1,55 @@
+package sql
+
+import (
+	"path"
+	"strconv"
+	"time"
+)
+
+// Result is a directory as written by fs search in the json, ndjson and csv formats, served by fs serve and sent by
+// notifiers. These are meant for scripts, so field names must not change.
+type Result struct {
+	Site      string `json:"site"`
+	Path      string `json:"path"`
+	Name      string `json:"name"`
+	Parent    string `json:"parent"`
+	Modified  string `json:"modified"`
+	Size      int64  `json:"size"`
+	NumFiles  int    `json:"num_files"`
+	FirstSeen string `json:"first_seen,omitempty"`
+	LastSeen  string `json:"last_seen,omitempty"`
+}
+
+// ResultFields are the names of the fields of a Result, in the order of Record.
+var ResultFields = []string{"site", "path", "name", "parent", "modified", "size", "num_files", "first_seen", "last_seen"}
+
+func formatRFC3339(t int64) string { return time.Unix(t, 0).UTC().Format(time.RFC3339) }
+
+// formatSeen formats t as formatRFC3339 does, unless it is unknown.
+func formatSeen(t int64) string {
+	if t == 0 {
+		return ""
+	}
+	return formatRFC3339(t)
+}
+
+// NewResult converts d to a Result.
+func NewResult(d Dir) Result {
+	return Result{
+		Site:      d.Site,
+		Path:      d.Path,
+		Name:      path.Base(d.Path),
+		Parent:    path.Dir(d.Path),
+		Modified:  formatRFC3339(d.Modified),
+		Size:      d.Size,
+		NumFiles:  d.NumFiles,
+		FirstSeen: formatSeen(d.FirstSeen),
+		LastSeen:  formatSeen(d.LastSeen),
+	}
+}
+
+// Record returns the fields of r as a CSV record.
+func (r Result) Record() []string {
+	return []string{r.Site, r.Path, r.Name, r.Parent, r.Modified, strconv.FormatInt(r.Size, 10),
+		strconv.Itoa(r.NumFiles), r.FirstSeen, r.LastSeen}
+}
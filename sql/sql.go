@@ -1,79 +1,160 @@
 package sql
 
 import (
+	"context"
+	stdsql "database/sql"
+	"errors"
 	"fmt"
+	"path"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
-const schema = `
--- Tables for sites and dirs
-CREATE TABLE IF NOT EXISTS site (
-  id INTEGER PRIMARY KEY,
-  name TEXT NOT NULL,
-  CONSTRAINT name_unique UNIQUE (name)
-);
-
-CREATE TABLE IF NOT EXISTS dir (
-  id INTEGER PRIMARY KEY,
-  site_id INTEGER NOT NULL,
-  path TEXT NOT NULL,
-  modified INTEGER NOT NULL,
-  CONSTRAINT path_unique UNIQUE(site_id, path),
-  FOREIGN KEY(site_id) REFERENCES site(id) ON DELETE CASCADE
-);
-
-CREATE INDEX IF NOT EXISTS dir_site_id_idx ON dir (site_id);
-
--- FTS index table
-CREATE VIRTUAL TABLE IF NOT EXISTS dir_fts USING fts4(
-  id INTEGER PRIMARY KEY,
-  site_id INTEGER NOT NULL,
-  path TEXT NOT NULL
-);
-
--- Triggers to keep FTS table up to date
-CREATE TRIGGER IF NOT EXISTS dir_bd BEFORE DELETE ON dir BEGIN
-  DELETE FROM dir_fts WHERE docid=old.rowid;
-END;
-
-CREATE TRIGGER IF NOT EXISTS dir_ai AFTER INSERT ON dir BEGIN
-  INSERT INTO dir_fts(id, site_id, path) VALUES (new.id, new.site_id, new.path);
-END;
-`
+// driverName is the go-sqlite3 driver with the functions needed by fs registered.
+const driverName = "sqlite3_fs"
+
+func init() {
+	stdsql.Register(driverName, &sqlite3.SQLiteDriver{ConnectHook: connectHook})
+}
+
+// connectHook prepares every new connection to the database.
+func connectHook(conn *sqlite3.SQLiteConn) error {
+	// Ensure foreign keys are enabled (defaults to off)
+	if _, err := conn.Exec("PRAGMA foreign_keys = ON", nil); err != nil {
+		return err
+	}
+	if err := conn.RegisterFunc("fs_terms", Terms, true); err != nil {
+		return err
+	}
+	if err := conn.RegisterFunc("fs_components", components, true); err != nil {
+		return err
+	}
+	return conn.RegisterFunc("fs_name", name, true)
+}
 
 type Site struct {
-	ID   int    `db:"id"`
-	Name string `db:"name"`
+	ID      int    `db:"id"`
+	Name    string `db:"name"`
+	Updated int64  `db:"updated"`
 }
 
 type Dir struct {
-	Site     string `db:"site"`
-	Path     string `db:"path"`
-	Modified int64  `db:"modified"`
+	ID        int64   `db:"id" json:"-"`
+	Site      string  `db:"site" json:"site"`
+	Path      string  `db:"path" json:"path"`
+	Modified  int64   `db:"modified" json:"modified"`
+	Size      int64   `db:"size" json:"size"`
+	NumFiles  int     `db:"num_files" json:"num_files"`
+	FirstSeen int64   `db:"first_seen" json:"first_seen"`
+	LastSeen  int64   `db:"last_seen" json:"last_seen"`
+	Rank      float64 `db:"rank" json:"-"` // BM25 score of the match. Lower is better
+}
+
+type File struct {
+	Dir
+	Owner string `db:"owner"`
+	Mode  uint32 `db:"mode"`
+}
+
+// Outcomes of a crawl.
+const (
+	OutcomeSuccess  = "success"
+	OutcomeFailure  = "failure"
+	OutcomeRejected = "rejected"
+)
+
+type Crawl struct {
+	ID        int    `db:"id"`
+	Site      string `db:"site"`
+	Started   int64  `db:"started"`
+	Finished  int64  `db:"finished"`
+	Outcome   string `db:"outcome"`
+	Error     string `db:"error"`
+	NumDirs   int    `db:"num_dirs"`
+	Delta     int    `db:"delta"`
+	NumErrors int    `db:"num_errors"`
+}
+
+// Watch is a saved search. Checked is the time from which directories are considered new, and Notify describes
+// where matches are sent.
+type Watch struct {
+	ID      int64  `db:"id"`
+	Query   string `db:"query"`
+	Site    string `db:"site"`
+	Notify  string `db:"notify"`
+	Checked int64  `db:"checked"`
+}
+
+// Query holds the parameters of a search. Keywords is a search query as described in ParseQuery, and the remaining
+// fields are optional.
+type Query struct {
+	Keywords string
+	Site     string
+	MinSize  int64
+	MaxSize  int64
+	Since    int64 // Only match entries first seen at or after this time
+	New      bool  // Only match entries first seen in the last update of their site
+	Order    []Order
+	Limit    int
+	Offset   int  // Number of results to skip
+	After    *Dir // Only match entries sorted after this result of the same query, for keyset pagination
 }
 
 type Client struct {
-	db *sqlx.DB
-	mu sync.Mutex
+	db  *sqlx.DB
+	mu  sync.Mutex
+	now func() time.Time
 }
 
+// New opens the database in filename and applies any pending migrations. Migrating writes to the database, so
+// callers must hold its lock.
 func New(filename string) (*Client, error) {
-	db, err := sqlx.Connect("sqlite3", filename)
+	c, err := Open(filename)
 	if err != nil {
 		return nil, err
 	}
-	// Ensure foreign keys are enabled (defaults to off)
-	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
+	if _, err := c.Migrate(); err != nil {
 		return nil, err
 	}
-	if _, err := db.Exec(schema); err != nil {
+	return c, nil
+}
+
+// OpenReadOnly opens the database in filename for reading only. The database must not have pending migrations, as
+// they cannot be applied.
+func OpenReadOnly(filename string) (*Client, error) {
+	c, err := Open("file:" + filename + "?mode=ro")
+	if err != nil {
 		return nil, err
 	}
-	return &Client{db: db}, nil
+	version, err := c.Version()
+	if err != nil {
+		return nil, err
+	}
+	if version < LatestVersion() {
+		return nil, fmt.Errorf("%s: database has pending migrations, run fs db migrate", filename)
+	}
+	return c, nil
+}
+
+// Open opens the database in filename without migrating it. Databases created by a newer version of fs are refused.
+func Open(filename string) (*Client, error) {
+	db, err := sqlx.Connect(driverName, filename)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{db: db, now: time.Now}
+	version, err := c.Version()
+	if err != nil {
+		return nil, err
+	}
+	if latest := LatestVersion(); version > latest {
+		return nil, fmt.Errorf("%s: database version %d is newer than the supported version %d", filename, version, latest)
+	}
+	return c, nil
 }
 
 func (c *Client) SelectSites() ([]Site, error) {
@@ -110,11 +191,16 @@ func (c *Client) Vacuum() error {
 func (c *Client) Optimize() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	_, err := c.db.Exec("INSERT INTO dir_fts (dir_fts) VALUES ('optimize')")
+	if _, err := c.db.Exec("INSERT INTO dir_fts (dir_fts) VALUES ('optimize')"); err != nil {
+		return err
+	}
+	_, err := c.db.Exec("INSERT INTO file_fts (file_fts) VALUES ('optimize')")
 	return err
 }
 
-func (c *Client) Insert(siteName string, dirs []Dir) error {
+// Insert inserts the directories and files of a site. Existing directories are updated and directories that are not
+// part of dirs are marked as deleted, while files are replaced.
+func (c *Client) Insert(siteName string, dirs []Dir, files []File) error {
 	// Ensure writes to SQLite db are serialized
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -123,85 +209,461 @@ func (c *Client) Insert(siteName string, dirs []Dir) error {
 		return err
 	}
 	defer tx.Rollback()
-	if _, err := tx.Exec("DELETE FROM site WHERE name = $1", siteName); err != nil {
+	if _, err := tx.Exec("INSERT OR IGNORE INTO site (name) VALUES ($1)", siteName); err != nil {
 		return err
 	}
-	if _, err := tx.Exec("INSERT INTO site (name) VALUES ($1)", siteName); err != nil {
+	var site Site
+	if err := tx.Get(&site, "SELECT * FROM site WHERE name = $1", siteName); err != nil {
 		return err
 	}
-	siteID := 0
-	if err := tx.Get(&siteID, "SELECT id FROM site WHERE name = $1", siteName); err != nil {
-		return err
+	// Ensure that the update time increases so that directories not seen in this update can be detected
+	now := c.now().Unix()
+	if now <= site.Updated {
+		now = site.Updated + 1
 	}
 	for _, d := range dirs {
-		if _, err := tx.Exec("INSERT INTO dir (site_id, path, modified) VALUES ($1, $2, $3)", siteID, d.Path, d.Modified); err != nil {
+		if _, err := tx.Exec(`INSERT INTO dir (site_id, path, modified, size, num_files, first_seen, last_seen)
+VALUES ($1, $2, $3, $4, $5, $6, $6)
+ON CONFLICT (site_id, path) DO UPDATE SET modified = excluded.modified, size = excluded.size,
+num_files = excluded.num_files, last_seen = excluded.last_seen, deleted = NULL`,
+			site.ID, d.Path, d.Modified, d.Size, d.NumFiles, now); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE dir SET deleted = $1 WHERE site_id = $2 AND last_seen < $1 AND deleted IS NULL", now, site.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM file WHERE site_id = $1", site.ID); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if _, err := tx.Exec("INSERT INTO file (site_id, path, name, size, owner, mode, modified) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			site.ID, f.Path, path.Base(f.Path), f.Size, f.Owner, f.Mode, f.Modified); err != nil {
 			return err
 		}
 	}
+	if _, err := tx.Exec("UPDATE site SET updated = $1 WHERE id = $2", now, site.ID); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
-func OrderByClause(s string) (string, error) {
-	parts := strings.SplitN(s, ":", 2)
-	if len(parts) == 0 {
-		return "", fmt.Errorf("could not parse order: %s", s)
+func (c *Client) CountDirs(siteName string) (int, error) {
+	count := 0
+	err := c.db.Get(&count, `SELECT COUNT(*) FROM dir INNER JOIN site ON dir.site_id = site.id
+WHERE site.name = $1 AND dir.deleted IS NULL`, siteName)
+	return count, err
+}
+
+func (c *Client) InsertCrawl(crawl Crawl) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.db.Exec(`INSERT INTO crawl (site, started, finished, outcome, error, num_dirs, delta, num_errors)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		crawl.Site, crawl.Started, crawl.Finished, crawl.Outcome, crawl.Error, crawl.NumDirs, crawl.Delta, crawl.NumErrors)
+	return err
+}
+
+// LastCrawl returns the most recent successful or unsuccessful crawl of a site. It returns nil if there is no such
+// crawl.
+func (c *Client) LastCrawl(siteName string, success bool) (*Crawl, error) {
+	op := "!="
+	if success {
+		op = "="
+	}
+	var crawls []Crawl
+	query := fmt.Sprintf("SELECT * FROM crawl WHERE site = $1 AND outcome %s $2 ORDER BY started DESC, id DESC LIMIT 1", op)
+	if err := c.db.Select(&crawls, query, siteName, OutcomeSuccess); err != nil {
+		return nil, err
+	}
+	if len(crawls) == 0 {
+		return nil, nil
+	}
+	return &crawls[0], nil
+}
+
+// Checkpoint returns the time user last checked for new directories, or 0 if user has never checked.
+func (c *Client) Checkpoint(user string) (int64, error) {
+	var checked []int64
+	if err := c.db.Select(&checked, "SELECT checked FROM checkpoint WHERE user = $1", user); err != nil {
+		return 0, err
+	}
+	if len(checked) == 0 {
+		return 0, nil
+	}
+	return checked[0], nil
+}
+
+func (c *Client) SetCheckpoint(user string, checked int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.db.Exec(`INSERT INTO checkpoint (user, checked) VALUES ($1, $2)
+ON CONFLICT (user) DO UPDATE SET checked = excluded.checked`, user, checked)
+	return err
+}
+
+// InsertWatch saves watch w. Only directories seen after the most recent update of any site will match the watch.
+func (c *Client) InsertWatch(w Watch) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, err := c.db.Exec(`INSERT INTO watch (query, site, notify, checked)
+VALUES ($1, $2, $3, (SELECT IFNULL(MAX(updated), 0) + 1 FROM site))`, w.Query, w.Site, w.Notify)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (c *Client) SelectWatches() ([]Watch, error) {
+	var watches []Watch
+	if err := c.db.Select(&watches, "SELECT * FROM watch ORDER BY id ASC"); err != nil {
+		return nil, err
+	}
+	return watches, nil
+}
+
+func (c *Client) DeleteWatch(id int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, err := c.db.Exec("DELETE FROM watch WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("no watch with id %d", id)
+	}
+	return nil
+}
+
+// MatchWatch returns the directories matching watch w that were first seen since it was last checked, and the time
+// to mark w as checked at once the matches have been handled, see AckWatch. Until then, the same directories match
+// again.
+func (c *Client) MatchWatch(w Watch) ([]Dir, int64, error) {
+	checked, err := c.NextCheckpoint(w.Checked)
+	if err != nil {
+		return nil, 0, err
+	}
+	dirs, err := c.SelectDirs(Query{Keywords: w.Query, Site: w.Site, Since: w.Checked, Order: []Order{{Field: "site"}, {Field: "path"}}})
+	if err != nil {
+		return nil, 0, err
+	}
+	return dirs, checked, nil
+}
+
+// NextCheckpoint returns the time to look for new directories since, after those first seen since since have been
+// shown. It must be called before selecting the directories, as it only covers updates that have finished.
+func (c *Client) NextCheckpoint(since int64) (int64, error) {
+	var updated int64
+	if err := c.db.Get(&updated, "SELECT IFNULL(MAX(updated), 0) FROM site"); err != nil {
+		return 0, err
+	}
+	// Every directory inserted by a later update is seen after the most recent update, even if the update started
+	// before now
+	if updated >= since {
+		return updated + 1, nil
+	}
+	return since, nil
+}
+
+// AckWatch marks watch w as checked at checked, as returned by MatchWatch.
+func (c *Client) AckWatch(w Watch, checked int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.db.Exec("UPDATE watch SET checked = $1 WHERE id = $2 AND checked < $1", checked, w.ID)
+	return err
+}
+
+// errFirstSeen is returned when searching files by when they were first seen, which is only tracked for directories.
+var errFirstSeen = errors.New("only directories can be searched by when they were first seen")
+
+// dirWhere excludes deleted directories from searches.
+const dirWhere = "dir.deleted IS NULL"
+
+func selectDirsQuery(q Query) (string, []interface{}, error) {
+	const columns = "site.name AS site, dir.path AS path, dir.modified, dir.size, dir.num_files, dir.first_seen, dir.last_seen, dir.id"
+	return selectQuery(q, "dir", columns, dirWhere)
+}
+
+func selectFilesQuery(q Query) (string, []interface{}, error) {
+	const columns = "site.name AS site, file.path AS path, file.modified, file.size, file.owner, file.mode, file.id"
+	return selectQuery(q, "file", columns, "")
+}
+
+// search holds the FROM and WHERE clauses of a search, which are shared by queries selecting and counting results.
+type search struct {
+	from  string
+	rank  string
+	conds []string
+	args  []interface{}
+}
+
+// newSearch returns the search for q in table, which is joined with its full-text index if the keywords of q contain
+// words to match. where is an additional condition.
+func newSearch(q Query, table, where string) (*search, error) {
+	n, err := ParseQuery(q.Keywords)
+	if err != nil {
+		return nil, err
 	}
-	field := parts[0]
-	order := ""
-	if len(parts) == 2 {
-		order = parts[1]
+	match, cond, args := compileQuery(n, table, nil)
+	s := &search{
+		from: fmt.Sprintf("%s\nINNER JOIN site ON %s.site_id = site.id", table, table),
+		rank: "0",
 	}
-	orderUpper := strings.ToUpper(order)
-	switch orderUpper {
-	case "":
-	case "ASC":
-	case "DESC":
-		break
-	default:
-		return "", fmt.Errorf("invalid order: %s", order)
+	if match != "" {
+		s.from = fmt.Sprintf("%s_fts\nINNER JOIN %s ON %s_fts.rowid = %s.id\nINNER JOIN site ON %s.site_id = site.id",
+			table, table, table, table, table)
+		s.rank = rankExpr(table + "_fts")
+		s.conds = append(s.conds, table+"_fts MATCH $1")
 	}
-	if order == "" {
-		return field, nil
+	if where != "" {
+		s.conds = append(s.conds, where)
 	}
-	return field + " " + orderUpper, nil
+	if cond != "" {
+		s.conds = append(s.conds, cond)
+	}
+	filters, args, err := filterConds(args, table, q)
+	if err != nil {
+		return nil, err
+	}
+	s.conds = append(s.conds, filters...)
+	s.args = args
+	return s, nil
+}
+
+func (s *search) where() string {
+	if len(s.conds) == 0 {
+		return ""
+	}
+	return "\nWHERE " + strings.Join(s.conds, " AND ")
 }
 
-func OrderByClauses(ss []string) (string, error) {
-	var orderByClauses []string
-	for _, s := range ss {
-		c, err := OrderByClause(s)
+// selectQuery returns a query selecting columns from table.
+func selectQuery(q Query, table, columns, where string) (string, []interface{}, error) {
+	s, err := newSearch(q, table, where)
+	if err != nil {
+		return "", nil, err
+	}
+	var keys []sortKey
+	if len(q.Order) > 0 || q.After != nil {
+		keys, err = sortKeys(q.Order, table)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
-		orderByClauses = append(orderByClauses, c)
 	}
-	return strings.Join(orderByClauses, ", "), nil
+	if q.After != nil {
+		var after string
+		after, s.args = afterCond(keys, q.After, s.rank, s.args)
+		s.conds = append(s.conds, after)
+	}
+	query := fmt.Sprintf("SELECT %s, %s AS rank FROM %s", columns, s.rank, s.from) + s.where()
+	if len(keys) > 0 {
+		query += " ORDER BY " + orderBy(keys)
+	}
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.Limit)
+	} else if q.Offset > 0 {
+		query += " LIMIT -1"
+	}
+	if q.Offset > 0 {
+		query += fmt.Sprintf(" OFFSET %d", q.Offset)
+	}
+	return query, s.args, nil
 }
 
-func selectDirsQuery(keywords, site, order string, limit int) (string, []interface{}) {
-	query := `SELECT site.name AS site, dir_fts.path, dir.modified FROM dir_fts
-INNER JOIN dir ON dir_fts.id = dir.id
-INNER JOIN site ON dir_fts.site_id = site.id
-WHERE dir_fts.path MATCH $1`
-	args := []interface{}{keywords}
-	if site != "" {
-		query += " AND site.name = $2"
-		args = append(args, site)
+// Facet is the number of search results having a value of a facet.
+type Facet struct {
+	Value string `db:"value" json:"value"`
+	Count int64  `db:"count" json:"count"`
+}
+
+// Facets are the fields search results can be grouped by.
+var Facets = []string{"site", "year", "month", "toplevel"}
+
+// facetExpr returns an expression for the value of facet in table.
+func facetExpr(facet, table string) (string, error) {
+	switch facet {
+	case "site":
+		return "site.name", nil
+	case "year":
+		return fmt.Sprintf("strftime('%%Y', %s.modified, 'unixepoch')", table), nil
+	case "month":
+		return fmt.Sprintf("strftime('%%Y-%%m', %s.modified, 'unixepoch')", table), nil
+	case "toplevel":
+		// The first component of the path, including the leading slash
+		return fmt.Sprintf("'/' || substr(%s.path, 2, instr(substr(%s.path, 2) || '/', '/') - 1)", table, table), nil
 	}
-	if order != "" {
-		query += " ORDER BY " + order
+	return "", fmt.Errorf("invalid facet %q: must be one of %s", facet, strings.Join(Facets, ", "))
+}
+
+// countQuery returns a query counting the results of q in table. If facet is not empty, results are counted per
+// value of facet, with the most common value first. Order and pagination of q are ignored.
+func countQuery(q Query, table, where, facet string) (string, []interface{}, error) {
+	s, err := newSearch(q, table, where)
+	if err != nil {
+		return "", nil, err
 	}
-	if limit > 0 {
-		query += fmt.Sprintf(" LIMIT %d", limit)
+	if facet == "" {
+		return "SELECT COUNT(*) FROM " + s.from + s.where(), s.args, nil
 	}
-	return query, args
+	expr, err := facetExpr(facet, table)
+	if err != nil {
+		return "", nil, err
+	}
+	query := fmt.Sprintf("SELECT %s AS value, COUNT(*) AS count FROM %s", expr, s.from) + s.where() +
+		" GROUP BY value ORDER BY count DESC, value ASC"
+	return query, s.args, nil
 }
 
-func (c *Client) SelectDirs(keywords, site, order string, limit int) ([]Dir, error) {
-	query, args := selectDirsQuery(keywords, site, order, limit)
+// filterConds returns the conditions for the filters of q on table. Arguments of the conditions are appended to
+// args.
+func filterConds(args []interface{}, table string, q Query) ([]string, []interface{}, error) {
+	var conds []string
+	if q.Site != "" {
+		args = append(args, q.Site)
+		conds = append(conds, fmt.Sprintf("site.name = $%d", len(args)))
+	}
+	if q.MinSize > 0 {
+		args = append(args, q.MinSize)
+		conds = append(conds, fmt.Sprintf("%s.size >= $%d", table, len(args)))
+	}
+	if q.MaxSize > 0 {
+		args = append(args, q.MaxSize)
+		conds = append(conds, fmt.Sprintf("%s.size <= $%d", table, len(args)))
+	}
+	// Only directories track when they were first seen
+	if table != "dir" && (q.Since > 0 || q.New) {
+		return nil, nil, errFirstSeen
+	}
+	if q.Since > 0 {
+		args = append(args, q.Since)
+		conds = append(conds, fmt.Sprintf("dir.first_seen >= $%d", len(args)))
+	}
+	if q.New {
+		conds = append(conds, "dir.first_seen = site.updated")
+	}
+	return conds, args, nil
+}
+
+// CountMatchingDirs returns the number of directories matching q.
+func (c *Client) CountMatchingDirs(q Query) (int64, error) {
+	return c.count(q, "dir", dirWhere)
+}
+
+// CountMatchingFiles returns the number of files matching q.
+func (c *Client) CountMatchingFiles(q Query) (int64, error) {
+	return c.count(q, "file", "")
+}
+
+func (c *Client) count(q Query, table, where string) (int64, error) {
+	query, args, err := countQuery(q, table, where, "")
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = c.db.Get(&count, query, args...)
+	return count, err
+}
+
+// FacetDirs returns the number of directories matching q for each value of facet, which is one of Facets.
+func (c *Client) FacetDirs(q Query, facet string) ([]Facet, error) {
+	return c.facet(q, "dir", dirWhere, facet)
+}
+
+// FacetFiles returns the number of files matching q for each value of facet, which is one of Facets.
+func (c *Client) FacetFiles(q Query, facet string) ([]Facet, error) {
+	return c.facet(q, "file", "", facet)
+}
+
+func (c *Client) facet(q Query, table, where, facet string) ([]Facet, error) {
+	query, args, err := countQuery(q, table, where, facet)
+	if err != nil {
+		return nil, err
+	}
+	var facets []Facet
+	if err := c.db.Select(&facets, query, args...); err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
+
+func (c *Client) SelectDirs(q Query) ([]Dir, error) {
+	query, args, err := selectDirsQuery(q)
+	if err != nil {
+		return nil, err
+	}
 	var dirs []Dir
 	if err := c.db.Select(&dirs, query, args...); err != nil {
 		return nil, err
 	}
 	return dirs, nil
 }
+
+// Rows is an iterator over the results of a search. Each result is read into a Dir or a File with Scan.
+type Rows struct {
+	rows *sqlx.Rows
+}
+
+// Next prepares the next result for Scan. It returns false when there are no more results or an error occurred.
+func (r *Rows) Next() bool { return r.rows.Next() }
+
+// Scan reads the current result into dest, which must be a *Dir for QueryDirs and a *File for QueryFiles.
+func (r *Rows) Scan(dest interface{}) error { return r.rows.StructScan(dest) }
+
+// Err returns the error, if any, that occurred during iteration.
+func (r *Rows) Err() error { return r.rows.Err() }
+
+// Close closes the iterator. It must be called unless Next has returned false.
+func (r *Rows) Close() error { return r.rows.Close() }
+
+// QueryDirs is like SelectDirs, but returns an iterator which reads results from the database as they are consumed.
+func (c *Client) QueryDirs(q Query) (*Rows, error) {
+	return c.QueryDirsContext(context.Background(), q)
+}
+
+// QueryDirsContext is like QueryDirs. The query is interrupted when ctx is done.
+func (c *Client) QueryDirsContext(ctx context.Context, q Query) (*Rows, error) {
+	query, args, err := selectDirsQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{rows: rows}, nil
+}
+
+// QueryFiles is like SelectFiles, but returns an iterator which reads results from the database as they are
+// consumed.
+func (c *Client) QueryFiles(q Query) (*Rows, error) {
+	return c.QueryFilesContext(context.Background(), q)
+}
+
+// QueryFilesContext is like QueryFiles. The query is interrupted when ctx is done.
+func (c *Client) QueryFilesContext(ctx context.Context, q Query) (*Rows, error) {
+	query, args, err := selectFilesQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{rows: rows}, nil
+}
+
+func (c *Client) SelectFiles(q Query) ([]File, error) {
+	query, args, err := selectFilesQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	if err := c.db.Select(&files, query, args...); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
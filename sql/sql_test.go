@@ -3,6 +3,7 @@ package sql
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func testClient() *Client {
@@ -15,7 +16,7 @@ func testClient() *Client {
 
 func TestSelectSites(t *testing.T) {
 	c := testClient()
-	if err := c.Insert("foo", nil); err != nil {
+	if err := c.Insert("foo", nil, nil); err != nil {
 		t.Fatal(err)
 	}
 	sites, err := c.SelectSites()
@@ -37,11 +38,11 @@ func TestInsert(t *testing.T) {
 		dirs []Dir
 	}{
 		{"foo", []Dir{{Path: "dir1"}}},
-		{"foo", []Dir{{Path: "dir2"}, {Path: "dir3"}}}, // Overwrites previous insert
+		{"foo", []Dir{{Path: "dir2"}, {Path: "dir3"}}}, // Marks dirs of previous insert as deleted
 		{"bar", nil},
 	}
 	for _, tt := range tests {
-		if err := c.Insert(tt.site, tt.dirs); err != nil {
+		if err := c.Insert(tt.site, tt.dirs, nil); err != nil {
 			t.Fatal(err)
 		}
 
@@ -54,7 +55,7 @@ func TestInsert(t *testing.T) {
 		}
 
 		var dirs []Dir
-		if err := c.db.Select(&dirs, "SELECT path, modified FROM dir WHERE site_id = $1", site.ID); err != nil {
+		if err := c.db.Select(&dirs, "SELECT path, modified FROM dir WHERE site_id = $1 AND deleted IS NULL", site.ID); err != nil {
 			t.Fatal(err)
 		}
 		if len(dirs) != len(tt.dirs) {
@@ -63,6 +64,110 @@ func TestInsert(t *testing.T) {
 	}
 }
 
+func TestInsertSeen(t *testing.T) {
+	c := testClient()
+	type dir struct {
+		Path      string `db:"path"`
+		FirstSeen int64  `db:"first_seen"`
+		LastSeen  int64  `db:"last_seen"`
+		Deleted   *int64 `db:"deleted"`
+	}
+	deleted := func(t int64) *int64 { return &t }
+	var tests = []struct {
+		now   int64
+		paths []string
+		out   []dir
+	}{
+		{10, []string{"/a", "/b"}, []dir{{"/a", 10, 10, nil}, {"/b", 10, 10, nil}}},
+		{20, []string{"/b", "/c"}, []dir{{"/a", 10, 10, deleted(20)}, {"/b", 10, 20, nil}, {"/c", 20, 20, nil}}},
+		{30, []string{"/a", "/c"}, []dir{{"/a", 10, 30, nil}, {"/b", 10, 20, deleted(30)}, {"/c", 20, 30, nil}}},
+		// Time of update always increases
+		{30, []string{"/a"}, []dir{{"/a", 10, 31, nil}, {"/b", 10, 20, deleted(30)}, {"/c", 20, 30, deleted(31)}}},
+	}
+	for _, tt := range tests {
+		c.now = func() time.Time { return time.Unix(tt.now, 0) }
+		var dirs []Dir
+		for _, p := range tt.paths {
+			dirs = append(dirs, Dir{Path: p})
+		}
+		if err := c.Insert("foo", dirs, nil); err != nil {
+			t.Fatal(err)
+		}
+		var got []dir
+		if err := c.db.Select(&got, "SELECT path, first_seen, last_seen, deleted FROM dir ORDER BY path"); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.out) {
+			t.Errorf("Insert(%q) at %d => %+v, want %+v", tt.paths, tt.now, got, tt.out)
+		}
+	}
+}
+
+func TestSelectDirsSeen(t *testing.T) {
+	c := testClient()
+	for i, site := range []string{"site1", "site2"} {
+		now := int64(i * 10)
+		c.now = func() time.Time { return time.Unix(now+10, 0) }
+		if err := c.Insert(site, []Dir{{Path: "/dir/foo"}, {Path: "/dir/bar"}}, nil); err != nil {
+			t.Fatal(err)
+		}
+		c.now = func() time.Time { return time.Unix(now+15, 0) }
+		if err := c.Insert(site, []Dir{{Path: "/dir/foo"}, {Path: "/dir/bar"}, {Path: "/dir/baz"}}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var tests = []struct {
+		since int64
+		new   bool
+		out   int
+	}{
+		{0, false, 6},
+		{15, false, 4},
+		{16, false, 3},
+		{25, false, 1},
+		{0, true, 2},
+		{20, true, 1},
+	}
+	for _, tt := range tests {
+		for _, keywords := range []string{"dir", ""} {
+			dirs, err := c.SelectDirs(Query{Keywords: keywords, Since: tt.since, New: tt.new})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(dirs); got != tt.out {
+				t.Errorf("SelectDirs(keywords=%q, since=%d, new=%t) => %d row(s), want %d", keywords, tt.since, tt.new, got, tt.out)
+			}
+		}
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	c := testClient()
+	var tests = []struct {
+		user    string
+		checked int64
+	}{
+		{"foo", 10},
+		{"foo", 20},
+		{"bar", 30},
+	}
+	if got, err := c.Checkpoint("foo"); err != nil || got != 0 {
+		t.Errorf("Checkpoint(%q) => (%d, %v), want (0, nil)", "foo", got, err)
+	}
+	for _, tt := range tests {
+		if err := c.SetCheckpoint(tt.user, tt.checked); err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.Checkpoint(tt.user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.checked {
+			t.Errorf("Checkpoint(%q) => %d, want %d", tt.user, got, tt.checked)
+		}
+	}
+}
+
 func TestSelectDirsQuery(t *testing.T) {
 	var tests = []struct {
 		keywords string
@@ -71,21 +176,24 @@ func TestSelectDirsQuery(t *testing.T) {
 		query    string
 		args     []interface{}
 	}{
-		{"foo", "", 0, `SELECT site.name AS site, dir_fts.path, dir.modified FROM dir_fts
-INNER JOIN dir ON dir_fts.id = dir.id
-INNER JOIN site ON dir_fts.site_id = site.id
-WHERE dir_fts.path MATCH $1 ORDER BY site.name ASC, dir.modified DESC`, []interface{}{"foo"}},
-		{"foo", "bar", 0, `SELECT site.name AS site, dir_fts.path, dir.modified FROM dir_fts
-INNER JOIN dir ON dir_fts.id = dir.id
-INNER JOIN site ON dir_fts.site_id = site.id
-WHERE dir_fts.path MATCH $1 AND site.name = $2 ORDER BY site.name ASC, dir.modified DESC`, []interface{}{"foo", "bar"}},
-		{"foo", "", 10, `SELECT site.name AS site, dir_fts.path, dir.modified FROM dir_fts
-INNER JOIN dir ON dir_fts.id = dir.id
-INNER JOIN site ON dir_fts.site_id = site.id
-WHERE dir_fts.path MATCH $1 ORDER BY site.name ASC, dir.modified DESC LIMIT 10`, []interface{}{"foo"}},
+		{"foo", "", 0, `SELECT site.name AS site, dir.path AS path, dir.modified, dir.size, dir.num_files, dir.first_seen, dir.last_seen, dir.id, bm25(dir_fts) AS rank FROM dir_fts
+INNER JOIN dir ON dir_fts.rowid = dir.id
+INNER JOIN site ON dir.site_id = site.id
+WHERE dir_fts MATCH $1 AND dir.deleted IS NULL ORDER BY site.name ASC, dir.modified DESC, dir.id ASC`, []interface{}{`"foo"`}},
+		{"foo", "bar", 0, `SELECT site.name AS site, dir.path AS path, dir.modified, dir.size, dir.num_files, dir.first_seen, dir.last_seen, dir.id, bm25(dir_fts) AS rank FROM dir_fts
+INNER JOIN dir ON dir_fts.rowid = dir.id
+INNER JOIN site ON dir.site_id = site.id
+WHERE dir_fts MATCH $1 AND dir.deleted IS NULL AND site.name = $2 ORDER BY site.name ASC, dir.modified DESC, dir.id ASC`, []interface{}{`"foo"`, "bar"}},
+		{"foo", "", 10, `SELECT site.name AS site, dir.path AS path, dir.modified, dir.size, dir.num_files, dir.first_seen, dir.last_seen, dir.id, bm25(dir_fts) AS rank FROM dir_fts
+INNER JOIN dir ON dir_fts.rowid = dir.id
+INNER JOIN site ON dir.site_id = site.id
+WHERE dir_fts MATCH $1 AND dir.deleted IS NULL ORDER BY site.name ASC, dir.modified DESC, dir.id ASC LIMIT 10`, []interface{}{`"foo"`}},
 	}
 	for _, tt := range tests {
-		query, args := selectDirsQuery(tt.keywords, tt.site, "site.name ASC, dir.modified DESC", tt.limit)
+		query, args, err := selectDirsQuery(Query{Keywords: tt.keywords, Site: tt.site, Order: []Order{{Field: "site"}, {Field: "modified", Descending: true}}, Limit: tt.limit})
+		if err != nil {
+			t.Fatal(err)
+		}
 		if query != tt.query || !reflect.DeepEqual(args, tt.args) {
 			t.Errorf("selectDirsQuery(%q, %q, %d) => (%q, %q), want (%q, %q)", tt.keywords, tt.site, tt.limit, query, args, tt.query, tt.args)
 		}
@@ -94,10 +202,10 @@ WHERE dir_fts.path MATCH $1 ORDER BY site.name ASC, dir.modified DESC LIMIT 10`,
 
 func TestSelectDirs(t *testing.T) {
 	c := testClient()
-	if err := c.Insert("site1", []Dir{{Path: "/dir/foo"}, {Path: "/dir/bar"}}); err != nil {
+	if err := c.Insert("site1", []Dir{{Path: "/dir/foo"}, {Path: "/dir/bar"}}, nil); err != nil {
 		t.Fatal(err)
 	}
-	if err := c.Insert("site2", []Dir{{Path: "/dir/foo"}, {Path: "/dir/bar"}}); err != nil {
+	if err := c.Insert("site2", []Dir{{Path: "/dir/foo"}, {Path: "/dir/bar"}}, nil); err != nil {
 		t.Fatal(err)
 	}
 	var tests = []struct {
@@ -111,7 +219,7 @@ func TestSelectDirs(t *testing.T) {
 		{"foo", "", 1, 1},
 	}
 	for _, tt := range tests {
-		dirs, err := c.SelectDirs(tt.keywords, tt.site, "", tt.limit)
+		dirs, err := c.SelectDirs(Query{Keywords: tt.keywords, Site: tt.site, Limit: tt.limit})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -121,36 +229,333 @@ func TestSelectDirs(t *testing.T) {
 	}
 }
 
-func TestOrderByClause(t *testing.T) {
+func TestSelectDirsSize(t *testing.T) {
+	c := testClient()
+	c.now = func() time.Time { return time.Unix(42, 0) }
+	dirs := []Dir{
+		{Path: "/dir/foo1", Size: 100, NumFiles: 2},
+		{Path: "/dir/foo2", Size: 200, NumFiles: 3},
+		{Path: "/dir/foo3", Size: 300, NumFiles: 4},
+	}
+	if err := c.Insert("site1", dirs, nil); err != nil {
+		t.Fatal(err)
+	}
 	var tests = []struct {
-		in  string
-		out string
-		err bool
+		min, max int64
+		out      []Dir
 	}{
-		{"foo:bar", "", true},
-		{"", "", false},
-		{"foo", "foo", false},
-		{"foo:desc", "foo DESC", false},
+		{0, 0, dirs},
+		{200, 0, dirs[1:]},
+		{0, 200, dirs[:2]},
+		{150, 250, dirs[1:2]},
 	}
 	for _, tt := range tests {
-		got, err := OrderByClause(tt.in)
-		if !tt.err && err != nil {
+		got, err := c.SelectDirs(Query{Keywords: "dir", MinSize: tt.min, MaxSize: tt.max, Order: []Order{{Field: "path"}}})
+		if err != nil {
 			t.Fatal(err)
 		}
-		if got != tt.out {
-			t.Errorf("orderByClause(%q) => %q, want %q", tt.in, got, tt.out)
+		for i := range got {
+			got[i].ID, got[i].Rank = 0, 0 // Tested separately
+		}
+		var want []Dir
+		for _, d := range tt.out {
+			d.Site = "site1"
+			d.FirstSeen = 42
+			d.LastSeen = 42
+			want = append(want, d)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("SelectDirs(min=%d, max=%d) => %+v, want %+v", tt.min, tt.max, got, want)
 		}
 	}
 }
 
-func TestOrderByClauses(t *testing.T) {
-	in := []string{"foo", "bar:desc"}
-	got, err := OrderByClauses(in)
+func TestSelectFiles(t *testing.T) {
+	c := testClient()
+	files := []File{
+		{Dir: Dir{Path: "/dir/foo/foo.txt", Size: 42}, Owner: "foo", Mode: 0644},
+		{Dir: Dir{Path: "/dir/foo/bar.txt"}},
+	}
+	if err := c.Insert("site1", []Dir{{Path: "/dir/foo"}}, files); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("site2", nil, files[:1]); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		keywords string
+		site     string
+		out      int
+	}{
+		{"foo", "", 2},
+		{"foo", "site2", 1},
+		{"bar", "", 1},
+		{"dir", "", 0}, // Only file names are indexed
+	}
+	for _, tt := range tests {
+		files, err := c.SelectFiles(Query{Keywords: tt.keywords, Site: tt.site, Order: []Order{{Field: "site"}, {Field: "path"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(files); got != tt.out {
+			t.Errorf("SelectFiles(%q, %q) => %d row(s), want %d", tt.keywords, tt.site, got, tt.out)
+		}
+	}
+	got, err := c.SelectFiles(Query{Keywords: "foo", Site: "site1"})
 	if err != nil {
 		t.Fatal(err)
 	}
-	want := "foo, bar DESC"
-	if got != want {
-		t.Errorf("orderByClauses(%q) => %q, want %q", in, got, want)
+	want := File{Dir: Dir{Site: "site1", Path: "/dir/foo/foo.txt", Size: 42}, Owner: "foo", Mode: 0644}
+	if len(got) == 1 {
+		got[0].ID, got[0].Rank = 0, 0 // Tested separately
+	}
+	if len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	for _, q := range []Query{{Since: 1}, {New: true}} {
+		if _, err := c.SelectFiles(q); err != errFirstSeen {
+			t.Errorf("SelectFiles(%+v) => %v, want %v", q, err, errFirstSeen)
+		}
+	}
+}
+
+func TestCountDirs(t *testing.T) {
+	c := testClient()
+	if err := c.Insert("site1", []Dir{{Path: "/dir/foo"}, {Path: "/dir/bar"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		site  string
+		count int
+	}{
+		{"site1", 2},
+		{"site2", 0},
+	}
+	for _, tt := range tests {
+		got, err := c.CountDirs(tt.site)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.count {
+			t.Errorf("CountDirs(%q) => %d, want %d", tt.site, got, tt.count)
+		}
+	}
+}
+
+func TestLastCrawl(t *testing.T) {
+	c := testClient()
+	crawls := []Crawl{
+		{Site: "site1", Started: 1, Finished: 2, Outcome: OutcomeSuccess, NumDirs: 10, Delta: 10},
+		{Site: "site1", Started: 3, Finished: 4, Outcome: OutcomeFailure, Error: "timeout"},
+		{Site: "site1", Started: 5, Finished: 6, Outcome: OutcomeSuccess, NumDirs: 8, Delta: -2, NumErrors: 1},
+		{Site: "site2", Started: 7, Finished: 8, Outcome: OutcomeFailure, Error: "refused"},
+	}
+	for _, crawl := range crawls {
+		if err := c.InsertCrawl(crawl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var tests = []struct {
+		site    string
+		success bool
+		out     *Crawl
+	}{
+		{"site1", true, &Crawl{ID: 3, Site: "site1", Started: 5, Finished: 6, Outcome: OutcomeSuccess, NumDirs: 8, Delta: -2, NumErrors: 1}},
+		{"site1", false, &Crawl{ID: 2, Site: "site1", Started: 3, Finished: 4, Outcome: OutcomeFailure, Error: "timeout"}},
+		{"site2", true, nil},
+		{"site2", false, &Crawl{ID: 4, Site: "site2", Started: 7, Finished: 8, Outcome: OutcomeFailure, Error: "refused"}},
+	}
+	for _, tt := range tests {
+		got, err := c.LastCrawl(tt.site, tt.success)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.out) {
+			t.Errorf("LastCrawl(%q, %t) => %+v, want %+v", tt.site, tt.success, got, tt.out)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	c := testClient()
+	insert := func(now int64, paths ...string) {
+		c.now = func() time.Time { return time.Unix(now, 0) }
+		var dirs []Dir
+		for _, p := range paths {
+			dirs = append(dirs, Dir{Path: p})
+		}
+		if err := c.Insert("site1", dirs, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert(10, "/foo/old")
+	id, err := c.InsertWatch(Watch{Query: "foo", Notify: "stdout"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		now   int64
+		paths []string
+		out   []string
+	}{
+		{20, []string{"/foo/old", "/bar/new"}, nil},
+		{30, []string{"/foo/old", "/bar/new", "/foo/new1", "/foo/new2"}, []string{"/foo/new1", "/foo/new2"}},
+		{30, []string{"/foo/old", "/foo/new1", "/foo/new3"}, []string{"/foo/new3"}},
+		{40, []string{"/foo/old", "/foo/new1", "/foo/new3"}, nil},
+	}
+	for _, tt := range tests {
+		insert(tt.now, tt.paths...)
+		watches, err := c.SelectWatches()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(watches) != 1 || watches[0].ID != id {
+			t.Fatalf("SelectWatches() => %+v, want watch %d", watches, id)
+		}
+		dirs, checked, err := c.MatchWatch(watches[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Matches are returned again until they are acknowledged
+		again, _, err := c.MatchWatch(watches[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(again, dirs) {
+			t.Errorf("MatchWatch() before AckWatch() => %+v, want %+v", again, dirs)
+		}
+		if err := c.AckWatch(watches[0], checked); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, d := range dirs {
+			got = append(got, d.Path)
+		}
+		if !reflect.DeepEqual(got, tt.out) {
+			t.Errorf("MatchWatch() after inserting %q at %d => %q, want %q", tt.paths, tt.now, got, tt.out)
+		}
+	}
+	if err := c.DeleteWatch(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteWatch(id); err == nil {
+		t.Errorf("DeleteWatch(%d) succeeded twice", id)
+	}
+}
+
+func TestSelectDirsRank(t *testing.T) {
+	c := testClient()
+	dirs := []Dir{
+		{Path: "/ubuntu/releases/18.04"},
+		{Path: "/ubuntu/ubuntu-18.04-desktop-amd64"},
+		{Path: "/debian/stretch"},
+		{Path: "/mirror/ubuntu/dists/bionic/main/binary-amd64"},
+	}
+	if err := c.Insert("site1", dirs, nil); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		keywords string
+		out      []string
+	}{
+		{"ubuntu amd64", []string{"/ubuntu/ubuntu-18.04-desktop-amd64", "/mirror/ubuntu/dists/bionic/main/binary-amd64"}},
+		{"deb*", []string{"/debian/stretch"}},
+		{"path:stretch", []string{"/debian/stretch"}},
+	}
+	for _, tt := range tests {
+		got, err := c.SelectDirs(Query{Keywords: tt.keywords, Order: []Order{{Field: "rank"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, d := range got {
+			paths = append(paths, d.Path)
+		}
+		if !reflect.DeepEqual(paths, tt.out) {
+			t.Errorf("SelectDirs(%q) => %q, want %q", tt.keywords, paths, tt.out)
+		}
+	}
+}
+
+func TestCountAndFacet(t *testing.T) {
+	c := testClient()
+	jan := time.Date(2018, 1, 15, 0, 0, 0, 0, time.UTC).Unix()
+	feb := time.Date(2018, 2, 15, 0, 0, 0, 0, time.UTC).Unix()
+	old := time.Date(2017, 12, 31, 0, 0, 0, 0, time.UTC).Unix()
+	dirs := []Dir{
+		{Path: "/tv/Some.Show.S01E01", Modified: jan},
+		{Path: "/tv/Some.Show.S01E02", Modified: feb},
+		{Path: "/movies/Some.Movie", Modified: old},
+		{Path: "/Other", Modified: feb},
+	}
+	if err := c.Insert("site1", dirs, []File{{Dir: Dir{Path: "/tv/Some.Show.S01E01/some.show.mkv", Modified: jan}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Insert("site2", []Dir{{Path: "/tv/Some.Show.S01E01", Modified: jan}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	count, err := c.CountMatchingDirs(Query{Keywords: "some", Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Errorf("CountMatchingDirs => %d, want 4", count)
+	}
+	if count, err = c.CountMatchingFiles(Query{Keywords: "mkv"}); err != nil || count != 1 {
+		t.Errorf("CountMatchingFiles => (%d, %v), want 1", count, err)
+	}
+	var tests = []struct {
+		keywords string
+		facet    string
+		out      []Facet
+	}{
+		{"some", "site", []Facet{{"site1", 3}, {"site2", 1}}},
+		{"", "year", []Facet{{"2018", 4}, {"2017", 1}}},
+		{"", "month", []Facet{{"2018-01", 2}, {"2018-02", 2}, {"2017-12", 1}}},
+		{"", "toplevel", []Facet{{"/tv", 3}, {"/Other", 1}, {"/movies", 1}}},
+		{"site:site2", "toplevel", []Facet{{"/tv", 1}}},
+	}
+	for _, tt := range tests {
+		got, err := c.FacetDirs(Query{Keywords: tt.keywords}, tt.facet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.out) {
+			t.Errorf("FacetDirs(%q, %q) => %+v, want %+v", tt.keywords, tt.facet, got, tt.out)
+		}
+	}
+	files, err := c.FacetFiles(Query{}, "toplevel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Facet{{"/tv", 1}}; !reflect.DeepEqual(files, want) {
+		t.Errorf("FacetFiles => %+v, want %+v", files, want)
+	}
+	if _, err := c.FacetDirs(Query{}, "path"); err == nil || err.Error() != `invalid facet "path": must be one of site, year, month, toplevel` {
+		t.Errorf("FacetDirs(path) = %v, want error", err)
+	}
+}
+
+func TestNextCheckpoint(t *testing.T) {
+	c := testClient()
+	c.now = func() time.Time { return time.Unix(100, 0) }
+	if err := c.Insert("site1", []Dir{{Path: "/foo"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		since, want int64
+	}{
+		{0, 101},
+		{100, 101},
+		{200, 200},
+	}
+	for _, tt := range tests {
+		got, err := c.NextCheckpoint(tt.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("NextCheckpoint(%d) = %d, want %d", tt.since, got, tt.want)
+		}
 	}
 }
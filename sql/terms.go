@@ -0,0 +1,126 @@
+package sql
+
+import (
+	"path"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// The full-text indexes do not index paths and names directly. Instead they index a normalized copy, kept in the
+// dir_terms and file_terms tables, where release-style names like Some.Show.S01E02.1080p-GRP are split into
+// separate, case-folded words without diacritics. Words in queries are normalized the same way, see ParseQuery.
+const termsTemplate = `
+CREATE TABLE dir_terms (
+  id INTEGER PRIMARY KEY,
+  path TEXT NOT NULL,
+  name TEXT NOT NULL
+);
+
+CREATE TRIGGER dir_ai AFTER INSERT ON dir BEGIN
+  INSERT INTO dir_terms(id, path, name) VALUES (new.id, fs_terms(new.path), fs_terms(fs_name(new.path)));
+END;
+
+CREATE TRIGGER dir_ad AFTER DELETE ON dir BEGIN
+  DELETE FROM dir_terms WHERE id=old.id;
+END;
+
+CREATE TRIGGER dir_au AFTER UPDATE OF path ON dir BEGIN
+  UPDATE dir_terms SET path=fs_terms(new.path), name=fs_terms(fs_name(new.path)) WHERE id=new.id;
+END;
+
+CREATE TABLE file_terms (
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL
+);
+
+CREATE TRIGGER file_ai AFTER INSERT ON file BEGIN
+  INSERT INTO file_terms(id, name) VALUES (new.id, fs_terms(new.name));
+END;
+
+CREATE TRIGGER file_ad AFTER DELETE ON file BEGIN
+  DELETE FROM file_terms WHERE id=old.id;
+END;
+
+CREATE TRIGGER file_au AFTER UPDATE OF name ON file BEGIN
+  UPDATE file_terms SET name=fs_terms(new.name) WHERE id=new.id;
+END;
+
+INSERT INTO dir_terms(id, path, name) SELECT id, fs_terms(path), fs_terms(fs_name(path)) FROM dir;
+INSERT INTO file_terms(id, name) SELECT id, fs_terms(name) FROM file;
+`
+
+// termsSchema returns the schema of the terms tables, indexing paths as given by opts.
+func termsSchema(opts IndexOptions) string {
+	if !opts.Components {
+		return termsTemplate
+	}
+	return strings.NewReplacer("fs_terms(new.path)", "fs_components(new.path)", "fs_terms(path)", "fs_components(path)").
+		Replace(termsTemplate)
+}
+
+// dropIndexSchema drops every full-text index and terms table, including those created by earlier versions.
+const dropIndexSchema = `
+DROP TRIGGER IF EXISTS dir_ai;
+DROP TRIGGER IF EXISTS dir_ad;
+DROP TRIGGER IF EXISTS dir_au;
+DROP TRIGGER IF EXISTS dir_bd;
+DROP TRIGGER IF EXISTS dir_bu;
+DROP TRIGGER IF EXISTS file_ai;
+DROP TRIGGER IF EXISTS file_ad;
+DROP TRIGGER IF EXISTS file_au;
+DROP TRIGGER IF EXISTS file_bd;
+DROP TRIGGER IF EXISTS file_bu;
+DROP TABLE IF EXISTS dir_fts;
+DROP TABLE IF EXISTS file_fts;
+DROP TABLE IF EXISTS dir_terms;
+DROP TABLE IF EXISTS file_terms;
+`
+
+// isSeparator returns whether r separates words in a release name.
+func isSeparator(r rune) bool {
+	switch r {
+	case '/', '.', '_', '-', '(', ')', '[', ']':
+		return true
+	}
+	return unicode.IsSpace(r)
+}
+
+// componentSeparator is indexed between the components of a path when they are indexed separately. It is a private
+// use character, which the tokenizer keeps as a word, but which Terms removes from paths and queries. A phrase can
+// therefore never match across it.
+const componentSeparator = "\ue000"
+
+// Terms normalizes s for the full-text index. s is split into words on path separators, dots, underscores, hyphens,
+// parentheses and brackets, and each word is case-folded with diacritics and private use characters removed.
+func Terms(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), runes.Remove(runes.In(unicode.Co)), norm.NFC, cases.Fold())
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		folded = strings.ToLower(s)
+	}
+	return strings.Join(strings.FieldsFunc(folded, isSeparator), " ")
+}
+
+// components normalizes path p like Terms, with a separator word between its components.
+func components(p string) string {
+	var terms []string
+	for _, c := range strings.Split(p, "/") {
+		if t := Terms(c); t != "" {
+			terms = append(terms, t)
+		}
+	}
+	return strings.Join(terms, " "+componentSeparator+" ")
+}
+
+// name returns the last component of path p.
+func name(p string) string {
+	if p == "/" {
+		return ""
+	}
+	return path.Base(p)
+}
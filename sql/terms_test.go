@@ -0,0 +1,148 @@
+package sql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTerms(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{"Some.Show.S01E02.1080p-GRP", "some show s01e02 1080p grp"},
+		{"/tv/Some_Show/(2018) [Season 1]", "tv some show 2018 season 1"},
+		{"Café Crème", "cafe creme"},
+		{"STRASSE", "strasse"},
+		{"Straße", "strasse"},
+		{"foo\ue000bar", "foobar"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Terms(tt.in); got != tt.out {
+			t.Errorf("Terms(%q) = %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestSelectDirsTerms(t *testing.T) {
+	c := testClient()
+	dirs := []Dir{
+		{Path: "/tv/Some.Show.S01E02.1080p-GRP"},
+		{Path: "/tv/Some_Show_S01E03_720p"},
+		{Path: "/music/Café Crème (1999)"},
+		{Path: "/s01e02/Other.Show"},
+	}
+	if err := c.Insert("site1", dirs, nil); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		keywords string
+		out      []string
+	}{
+		{"s01e02", []string{"/s01e02/Other.Show", "/tv/Some.Show.S01E02.1080p-GRP"}},
+		{"name:s01e02", []string{"/tv/Some.Show.S01E02.1080p-GRP"}},
+		{"Some.Show", []string{"/tv/Some.Show.S01E02.1080p-GRP", "/tv/Some_Show_S01E03_720p"}},
+		{"1080P", []string{"/tv/Some.Show.S01E02.1080p-GRP"}},
+		{"cafe", []string{"/music/Café Crème (1999)"}},
+		{"CRÈME", []string{"/music/Café Crème (1999)"}},
+		{"s01e0*", []string{"/s01e02/Other.Show", "/tv/Some.Show.S01E02.1080p-GRP", "/tv/Some_Show_S01E03_720p"}},
+	}
+	for _, tt := range tests {
+		got, err := c.SelectDirs(Query{Keywords: tt.keywords, Order: []Order{{Field: "path"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, d := range got {
+			paths = append(paths, d.Path)
+		}
+		if !reflect.DeepEqual(paths, tt.out) {
+			t.Errorf("SelectDirs(%q) => %q, want %q", tt.keywords, paths, tt.out)
+		}
+	}
+}
+
+func TestReindex(t *testing.T) {
+	c := testClient()
+	if err := c.Insert("site1", []Dir{{Path: "/foo/Bar.Baz"}}, []File{{Dir: Dir{Path: "/foo/Bar.Baz/a.txt"}}}); err != nil {
+		t.Fatal(err)
+	}
+	// Empty the terms table to verify that the index is rebuilt from the stored paths
+	c.db.MustExec("DELETE FROM dir_terms")
+	if err := c.Reindex(IndexOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := c.SelectDirs(Query{Keywords: "baz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 {
+		t.Errorf("got %d dirs after reindex, want 1", len(dirs))
+	}
+	files, err := c.SelectFiles(Query{Keywords: "txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %d files after reindex, want 1", len(files))
+	}
+}
+
+func TestComponents(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{"/tv/Some.Show/S01E02", "tv \ue000 some show \ue000 s01e02"},
+		{"/", ""},
+		{"/...", ""},
+	}
+	for _, tt := range tests {
+		if got := components(tt.in); got != tt.out {
+			t.Errorf("components(%q) = %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestReindexComponents(t *testing.T) {
+	c := testClient()
+	if err := c.Insert("site1", []Dir{{Path: "/tv/Some.Show/S01E02"}, {Path: "/tv/Some.Show.S01E03"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		opts     IndexOptions
+		keywords string
+		out      []string
+	}{
+		{IndexOptions{}, `"show s01e02"`, []string{"/tv/Some.Show/S01E02"}},
+		{IndexOptions{Components: true}, `"show s01e02"`, nil},
+		{IndexOptions{Components: true}, `"show s01e03"`, []string{"/tv/Some.Show.S01E03"}},
+		{IndexOptions{Components: true}, "show s01e02", []string{"/tv/Some.Show/S01E02"}},
+		{IndexOptions{Components: true}, `"some show"`, []string{"/tv/Some.Show.S01E03", "/tv/Some.Show/S01E02"}},
+		{IndexOptions{Components: true}, "NEAR(tv some, 1)", []string{"/tv/Some.Show.S01E03", "/tv/Some.Show/S01E02"}},
+	}
+	for _, tt := range tests {
+		if err := c.Reindex(tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.SelectDirs(Query{Keywords: tt.keywords, Order: []Order{{Field: "path"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, d := range got {
+			paths = append(paths, d.Path)
+		}
+		if !reflect.DeepEqual(paths, tt.out) {
+			t.Errorf("SelectDirs(%q) with %+v => %q, want %q", tt.keywords, tt.opts, paths, tt.out)
+		}
+	}
+	// Directories inserted later are indexed the same way
+	if err := c.Insert("site1", []Dir{{Path: "/tv/Other.Show/S01E02"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.SelectDirs(Query{Keywords: `"show s01e02"`}); err != nil || len(got) != 0 {
+		t.Errorf("got %d dirs, %v, want none", len(got), err)
+	}
+}